@@ -0,0 +1,175 @@
+package argocd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+const (
+	// inClusterServer - destination server of the cluster in which ArgoCD runs
+	inClusterServer = "https://kubernetes.default.svc"
+	// defaultAppProject - project of ArgoCD which the applications belong to if no project is set
+	defaultAppProject = "default"
+)
+
+// appProjectGVK - the AppProject type of ArgoCD is not part of the argocd-operator scheme, hence it is handled as unstructured object
+var appProjectGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "AppProject"}
+
+// +kubebuilder:rbac:groups=argoproj.io,resources=appprojects,verbs=get;list;create;update;patch;delete
+
+// syncAppProjects manages an AppProject per bound namespace whose only destination is the namespace if it is enabled by
+// the annotation, the projects of namespaces which are not bound anymore are deleted (all of them if it is not 'true',
+// removed or the instance runs in cluster mode)
+func (r *Reconciler) syncAppProjects(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, namespaces []string, cluster bool) (err error) {
+	ctx, span := tracing.Start(ctx, "SyncAppProjects")
+	defer func() { tracing.End(span, err) }()
+
+	value, ok := obj.Annotations[constants.AnnotationAppProjects]
+	if value != "true" || cluster {
+		namespaces = nil
+	}
+
+	list := unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(appProjectGVK.GroupVersion().WithKind(appProjectGVK.Kind + "List"))
+	if err := r.List(ctx, &list, client.InNamespace(obj.Namespace), client.MatchingLabels{constants.LabelManagedBy: obj.Name}); err != nil {
+		// there are no projects to delete if the AppProject CRD is not installed and the projects were never enabled
+		if !ok && (meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err)) {
+			return nil
+		}
+		return err
+	}
+
+	bound := make(map[string]bool)
+	for _, namespace := range namespaces {
+		bound[namespace] = true
+	}
+	for i := range list.Items {
+		if project := &list.Items[i]; !bound[project.GetName()] {
+			if err := r.Delete(ctx, project); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+
+	for _, namespace := range namespaces {
+		if err := r.syncAppProject(ctx, obj, namespace); err != nil {
+			return err
+		}
+	}
+
+	if value == "true" && !cluster && obj.Annotations[constants.AnnotationRestrictDefaultAppProject] == "true" {
+		return r.restrictDefaultAppProject(ctx, obj)
+	}
+	return nil
+}
+
+// restrictDefaultAppProject removes all destinations of the 'default' project, otherwise its applications can still
+// target any namespace. The destinations are not restored if the restriction is disabled again.
+func (r *Reconciler) restrictDefaultAppProject(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) error {
+	project := &unstructured.Unstructured{}
+	project.SetGroupVersionKind(appProjectGVK)
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: defaultAppProject}, project); err != nil {
+		// the project is restricted once ArgoCD created it
+		return client.IgnoreNotFound(err)
+	}
+
+	// a project which is managed for the bound namespace 'default' is already restricted to it
+	if project.GetLabels()[constants.LabelManagedBy] == obj.Name {
+		return nil
+	}
+
+	current, _, err := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	if err == nil && len(current) == 0 {
+		return nil
+	}
+	if err := unstructured.SetNestedSlice(project.Object, []interface{}{}, "spec", "destinations"); err != nil {
+		return err
+	}
+	if err := r.Update(ctx, project); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(obj, corev1.EventTypeNormal, constants.EventReasonDefaultAppProjectRestricted,
+		"Removed the destinations of the AppProject %s, only the managed projects can deploy", defaultAppProject)
+	return nil
+}
+
+// syncAppProject creates or updates the AppProject of the namespace, the source repositories and the other fields of an
+// existing project are left untouched in order that they can be restricted by hand
+func (r *Reconciler) syncAppProject(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, namespace string) error {
+	destinations := []interface{}{
+		map[string]interface{}{"server": inClusterServer, "namespace": namespace},
+	}
+
+	project := &unstructured.Unstructured{}
+	project.SetGroupVersionKind(appProjectGVK)
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: namespace}, project); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		project = &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"description":  fmt.Sprintf("Applications of the namespace %s, managed by the argocd-operator-extension", namespace),
+				"sourceRepos":  []interface{}{"*"},
+				"destinations": destinations,
+			},
+		}}
+		project.SetGroupVersionKind(appProjectGVK)
+		project.SetName(namespace)
+		project.SetNamespace(obj.Namespace)
+		project.SetLabels(map[string]string{constants.LabelManagedBy: obj.Name})
+		if err := controllerutil.SetControllerReference(obj, project, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, project)
+	}
+
+	// never take over a project which was created by someone else (e.g. the 'default' project)
+	if project.GetLabels()[constants.LabelManagedBy] != obj.Name {
+		r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonAppProjectConflict,
+			"AppProject %s is not managed by the extension, the namespace %s is not restricted to it", namespace, namespace)
+		return nil
+	}
+
+	current, _, err := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	if err != nil || !equalDestinations(current, destinations) {
+		if err := unstructured.SetNestedSlice(project.Object, destinations, "spec", "destinations"); err != nil {
+			return err
+		}
+		return r.Update(ctx, project)
+	}
+	return nil
+}
+
+// equalDestinations compares the server and namespace of the destinations of an AppProject in order, other fields of
+// the current destinations (e.g. the name of the cluster) are not managed
+func equalDestinations(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, ok := a[i].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		y := b[i].(map[string]interface{})
+		if len(x) != len(y) || x["server"] != y["server"] || x["namespace"] != y["namespace"] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+package argocd_test
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	controller "github.com/snorwin/argocd-operator-extension/controllers/argocd"
+)
+
+var _ = Describe("AppProjects", func() {
+	var (
+		mockCtrl *gomock.Controller
+		mockHelm *mock_helm.MockClient
+	)
+
+	BeforeEach(func() {
+		testHelmDirectory()
+		Ω(os.Setenv(constants.EnvClusterArgoCDNamespacedNames, "")).ShouldNot(HaveOccurred())
+
+		scheme.Scheme.AddKnownTypeWithName(testAppProjectGVK, &unstructured.Unstructured{})
+		scheme.Scheme.AddKnownTypeWithName(testAppProjectGVK.GroupVersion().WithKind("AppProjectList"), &unstructured.UnstructuredList{})
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHelm = mock_helm.NewMockClient(mockCtrl)
+		mockHelm.
+			EXPECT().
+			Upgrade(gomock.Any(), gomock.Any(), gomock.Any(), true).
+			Return(nil).
+			AnyTimes()
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+	It("should_not_manage_projects_without_annotation", func() {
+		argocd := testAppProjectArgoCD("")
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd))
+		testReconcileWith(r, argocd)
+
+		Ω(testAppProjects(r, argocd.Namespace)).Should(BeEmpty())
+	})
+	It("should_create_project_per_bound_namespace", func() {
+		argocd := testAppProjectArgoCD("true")
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd))
+		testReconcileWith(r, argocd)
+
+		projects := testAppProjects(r, argocd.Namespace)
+		Ω(projects).Should(HaveKeyWithValue("default", []string{"default"}))
+		Ω(projects).Should(HaveKeyWithValue("myapp", []string{"myapp"}))
+		Ω(projects).Should(HaveLen(2))
+
+		project := testAppProject(r, argocd.Namespace, "myapp")
+		Ω(project.GetLabels()).Should(HaveKeyWithValue(constants.LabelManagedBy, argocd.Name))
+		Ω(project.GetOwnerReferences()).Should(HaveLen(1))
+		Ω(project.GetOwnerReferences()[0].Name).Should(Equal(argocd.Name))
+	})
+	It("should_sync_projects_if_bindings_change", func() {
+		argocd := testAppProjectArgoCD("true")
+		namespace := testAppProjectNamespace("myapp", argocd)
+
+		r, _ := testReconciler(mockHelm, argocd, namespace)
+		testReconcileWith(r, argocd)
+
+		// restricting the source repositories by hand is kept
+		project := testAppProject(r, argocd.Namespace, "myapp")
+		Ω(unstructured.SetNestedStringSlice(project.Object, []string{"https://github.com/snorwin/myapp"}, "spec", "sourceRepos")).ShouldNot(HaveOccurred())
+		Ω(unstructured.SetNestedSlice(project.Object, []interface{}{map[string]interface{}{"server": "*", "namespace": "*"}}, "spec", "destinations")).ShouldNot(HaveOccurred())
+		Ω(r.Update(context.TODO(), project)).ShouldNot(HaveOccurred())
+
+		testReconcileWith(r, argocd)
+		project = testAppProject(r, argocd.Namespace, "myapp")
+		repos, _, err := unstructured.NestedStringSlice(project.Object, "spec", "sourceRepos")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(repos).Should(Equal([]string{"https://github.com/snorwin/myapp"}))
+		Ω(testAppProjects(r, argocd.Namespace)).Should(HaveKeyWithValue("myapp", []string{"myapp"}))
+
+		// unbind the namespace
+		namespace.Labels = nil
+		Ω(r.Update(context.TODO(), namespace)).ShouldNot(HaveOccurred())
+
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).ShouldNot(HaveKey("myapp"))
+	})
+	It("should_delete_projects_if_disabled", func() {
+		argocd := testAppProjectArgoCD("true")
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd))
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).Should(HaveLen(2))
+
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		actual.Annotations[constants.AnnotationAppProjects] = "false"
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).Should(BeEmpty())
+	})
+	It("should_delete_projects_if_annotation_is_removed", func() {
+		argocd := testAppProjectArgoCD("true")
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd))
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).Should(HaveLen(2))
+
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		delete(actual.Annotations, constants.AnnotationAppProjects)
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).Should(BeEmpty())
+	})
+	It("should_not_take_over_foreign_projects", func() {
+		argocd := testAppProjectArgoCD("true")
+
+		foreign := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"destinations": []interface{}{map[string]interface{}{"server": "*", "namespace": "*"}},
+			},
+		}}
+		foreign.SetGroupVersionKind(testAppProjectGVK)
+		foreign.SetName("default")
+		foreign.SetNamespace(argocd.Namespace)
+
+		r, recorder := testReconciler(mockHelm, argocd, foreign)
+		testReconcileWith(r, argocd)
+
+		Ω(testAppProjects(r, argocd.Namespace)).Should(HaveKeyWithValue("default", []string{"*"}))
+		Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonAppProjectConflict)))
+	})
+	It("should_restrict_default_project_if_enabled", func() {
+		argocd := testAppProjectArgoCD("true")
+		argocd.Namespace = "argocd"
+		argocd.Annotations[constants.AnnotationRestrictDefaultAppProject] = "true"
+
+		foreign := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"destinations": []interface{}{map[string]interface{}{"server": "*", "namespace": "*"}},
+			},
+		}}
+		foreign.SetGroupVersionKind(testAppProjectGVK)
+		foreign.SetName("default")
+		foreign.SetNamespace(argocd.Namespace)
+
+		r, recorder := testReconciler(mockHelm, argocd, foreign, testAppProjectNamespace("myapp", argocd))
+		testReconcileWith(r, argocd)
+
+		projects := testAppProjects(r, argocd.Namespace)
+		Ω(projects).Should(HaveKeyWithValue("default", BeEmpty()))
+		Ω(projects).Should(HaveKeyWithValue("myapp", []string{"myapp"}))
+		Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonDefaultAppProjectRestricted)))
+	})
+	It("should_delete_projects_in_cluster_mode", func() {
+		argocd := testAppProjectArgoCD("true")
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd))
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).Should(HaveLen(2))
+
+		Ω(os.Setenv(constants.EnvClusterArgoCDNamespacedNames, argocd.Namespace+"/"+argocd.Name)).ShouldNot(HaveOccurred())
+		testReconcileWith(r, argocd)
+		Ω(testAppProjects(r, argocd.Namespace)).Should(BeEmpty())
+	})
+})
+
+var testAppProjectGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "AppProject"}
+
+func testAppProjectArgoCD(value string) *argoprojv1alpha1.ArgoCD {
+	argocd := &argoprojv1alpha1.ArgoCD{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "argocd",
+			Namespace:   "default",
+			Finalizers:  []string{constants.FinalizerName},
+			Annotations: map[string]string{},
+		},
+	}
+	if value != "" {
+		argocd.Annotations[constants.AnnotationAppProjects] = value
+	}
+	return argocd
+}
+
+func testAppProjectNamespace(name string, argocd *argoprojv1alpha1.ArgoCD) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: name,
+		Labels: map[string]string{
+			constants.LabelArgoCDName:      argocd.Name,
+			constants.LabelArgoCDNamespace: argocd.Namespace,
+		},
+	}}
+}
+
+func testAppProject(r *controller.Reconciler, namespace, name string) *unstructured.Unstructured {
+	project := &unstructured.Unstructured{}
+	project.SetGroupVersionKind(testAppProjectGVK)
+	Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, project)).ShouldNot(HaveOccurred())
+	return project
+}
+
+// testAppProjects returns the destination namespaces by project name
+func testAppProjects(r *controller.Reconciler, namespace string) map[string][]string {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(testAppProjectGVK.GroupVersion().WithKind("AppProjectList"))
+	Ω(r.List(context.TODO(), list, client.InNamespace(namespace))).ShouldNot(HaveOccurred())
+
+	projects := map[string][]string{}
+	for _, project := range list.Items {
+		destinations, _, err := unstructured.NestedSlice(project.Object, "spec", "destinations")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		namespaces := []string{}
+		for _, destination := range destinations {
+			namespaces = append(namespaces, destination.(map[string]interface{})["namespace"].(string))
+		}
+		projects[project.GetName()] = namespaces
+	}
+	return projects
+}
@@ -2,30 +2,52 @@ package argocd
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-logr/logr"
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
 	"github.com/snorwin/argocd-operator-extension/pkg/constants"
 	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+	"github.com/snorwin/argocd-operator-extension/pkg/maintenance"
 	"github.com/snorwin/argocd-operator-extension/pkg/mapper"
+	"github.com/snorwin/argocd-operator-extension/pkg/metrics"
+	"github.com/snorwin/argocd-operator-extension/pkg/policy"
+	"github.com/snorwin/argocd-operator-extension/pkg/sharding"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
 	"github.com/snorwin/argocd-operator-extension/pkg/utils"
 	"github.com/snorwin/jsonpatch"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/argoproj-labs/argocd-operator/pkg/common"
 )
 
 var (
@@ -49,17 +71,82 @@ type Reconciler struct {
 	Log    logr.Logger
 	Scheme *runtime.Scheme
 
+	// Recorder records events for the ArgoCD instances
+	Recorder record.EventRecorder
+
 	// HelmFactory is a factory function to create new Helm clients
 	HelmFactory helm.ClientFactory
 
+	// NamespaceEventWindow is the time window in which the namespace events of an ArgoCD instance are coalesced into a
+	// single reconciliation, 0 reconciles the instance on every event immediately
+	NamespaceEventWindow time.Duration
+
+	// MaxConcurrentReconciles is the maximum number of ArgoCD instances which are reconciled concurrently (default: 1)
+	MaxConcurrentReconciles int
+
+	// RateLimiter limits the requeues of the reconcile requests, the controller default is used if nil
+	RateLimiter workqueue.RateLimiter
+
+	// ResyncInterval is the interval in which the ArgoCD instances are reconciled periodically and after permanent
+	// errors, 0 disables the periodic reconciliation
+	ResyncInterval time.Duration
+
+	// Sharder restricts the reconciler to the ArgoCD instances assigned to this replica, if set
+	Sharder *sharding.Sharder
+
+	// Mirrors rewrites the registries of the target images, e.g. to the mirror of an air-gapped cluster
+	Mirrors image.Rules
+	// EnforceMirrors rewrites the images of all components including the ones set by hand, regardless of the policy
+	EnforceMirrors bool
+
+	// ImageHistoryLimit is the maximum number of image history entries per instance (default: 10)
+	ImageHistoryLimit int
+
+	// NetworkPolicyExternalCIDR is the CIDR outside of the cluster which Argo CD can reach with network policies
+	NetworkPolicyExternalCIDR string
+	// NetworkPolicyClusterCIDRs are the pod and service CIDRs which are excluded from the external CIDR, the egress of
+	// Argo CD is not restricted as long as they are not set
+	NetworkPolicyClusterCIDRs []string
+
+	// Catalog provides the target images of the ArgoCD components, the environment variables are used if nil
+	Catalog *catalog.Catalog
+
+	// StagedRollout leaves the ArgoCD image updates of the instances with the 'Always' policy to the Rollout
+	StagedRollout bool
+
+	// Resolver pins the image updates to the digest of their tag and verifies that they exist before the ArgoCD
+	// instances are patched, the images are not resolved if it is nil
+	Resolver image.Resolver
+
+	// RBACPolicyRoles maps the Kubernetes roles to the Argo CD access which is generated into the RBAC policy of the
+	// instances with the rbac-policy annotation, the RoleBindings are not watched if it is nil
+	RBACPolicyRoles policy.Roles
+
+	// RemoteClientFactory creates the clients of the remote clusters of the instances (default: controller-runtime client)
+	RemoteClientFactory RemoteClientFactory
+
+	// APIReader reads the Secrets of the remote clusters without cache, the client is used if nil
+	APIReader client.Reader
+
+	// Clock is used to check the maintenance windows of the image updates (default: real clock)
+	Clock clock.PassiveClock
+
 	// mapper relates namespaces to ArgoCD instances and vice versa
 	mapper mapper.Mapper
+
+	// paused maps the paused instances to who paused them, the event is only created if the pause starts
+	paused sync.Map
+
+	// remoteClients caches the clients of the remote clusters by kubeconfig Secret
+	remoteClients sync.Map
 }
 
 // +kubebuilder:rbac:groups=argoproj.io,resources=argocds,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=argoproj.io,resources=argocds/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 
 // SetupWithManager register the ArgoCD Reconciler to the Manager
 func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -67,22 +154,88 @@ func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.HelmFactory == nil {
 		r.HelmFactory = helm.NewClientForNamespace
 	}
+	r.HelmFactory = metrics.InstrumentHelmClientFactory(r.HelmFactory)
+
+	// set default remote client factory if it was not set before
+	if r.RemoteClientFactory == nil {
+		r.RemoteClientFactory = func(config *rest.Config) (client.Client, error) {
+			return client.New(config, client.Options{Scheme: mgr.GetScheme()})
+		}
+	}
+
+	// set default event recorder if it was not set before
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("argocd-operator-extension")
+	}
+
+	// register the field indexes used to map namespaces to ArgoCD instances
+	if err := mapper.IndexFields(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		return err
+	}
+	r.mapper = mapper.Mapper{Reader: mgr.GetCache(), Log: r.Log.WithName("mapper")}
+
+	blder := ctrl.NewControllerManagedBy(mgr)
+	if r.Sharder != nil {
+		// reconcile all instances when the shard members change in order that the new owners take over
+		blder = blder.Watches(r.Sharder.Source(), &handler.EnqueueRequestForObject{})
+	}
+	if r.Catalog != nil {
+		// reconcile all instances when the target images change in order to apply their update policies
+		blder = blder.Watches(r.Catalog.Source(), &handler.EnqueueRequestForObject{})
+	}
+
+	if r.RBACPolicyRoles != nil {
+		// reconcile the instances which bound the namespace of a RoleBinding in order to update their RBAC policy
+		blder = blder.Watches(&source.Kind{Type: &rbacv1.RoleBinding{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.mapper.MapNamespaced)},
+			builder.WithPredicates(groupBindingsChanged()))
+	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	return blder.
 		For(&argoprojv1alpha1.ArgoCD{}).
-		Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: &r.mapper}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, &mapper.EventHandler{Mapper: &r.mapper, Window: r.NamespaceEventWindow},
+			builder.WithPredicates(mapper.BindingLabelsChanged())).
 		Complete(r)
 }
 
 // Reconcile create the RBAC (role bindings, roles and service accounts) for an ArgoCD instance
 func (r *Reconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx, span := tracing.Start(context.Background(), "Reconcile", tracing.ArgoCD(req.NamespacedName.String()))
+
+	result, err := r.reconcileArgoCD(ctx, req)
+	tracing.End(span, err)
+
+	if IsPermanent(err) {
+		// don't hot-loop on errors which are not resolved by retrying, try again after the resync interval
+		r.Log.Error(err, "unable to reconcile ArgoCD instance", "argocd", req.NamespacedName)
+		return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+	}
+
+	// transient errors are requeued by the rate limiter
+	return result, err
+}
+
+// reconcileArgoCD returns a PermanentError if retrying the request with backoff is pointless
+func (r *Reconciler) reconcileArgoCD(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := r.Log.WithValues("argocd", req.NamespacedName)
 
+	// skip the instances which are assigned to another replica
+	if r.Sharder != nil && !r.Sharder.Owns(req.NamespacedName) {
+		metrics.ForgetInstance(req.NamespacedName.String())
+		return reconcile.Result{}, nil
+	}
+
 	// get reconciled object
 	obj := argoprojv1alpha1.ArgoCD{}
-	if err := r.Get(ctx, req.NamespacedName, &obj); err != nil {
+	if err := r.get(ctx, req.NamespacedName, &obj); err != nil {
 		if errors.IsNotFound(err) {
+			metrics.ForgetInstance(req.NamespacedName.String())
+			r.paused.Delete(req.NamespacedName)
+
 			// return and don't requeue
 			return reconcile.Result{}, nil
 		}
@@ -90,167 +243,587 @@ func (r *Reconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return reconcile.Result{}, err
 	}
 
-	// create a helm client and inject logger and storage driver
-	helm, err := r.HelmFactory(req.Namespace, helm.WithLogger(logger), helm.WithHelmDriver(driver), helm.WithMaxHistory(maxHistory))
+	// skip all mutations (images, helm release and finalizer) of paused instances, the namespace bindings are still
+	// tracked by the labels and applied once the instance is resumed
+	if obj.Annotations[constants.AnnotationPaused] == "true" {
+		by := pausedBy(&obj)
+		logger.Info("reconciliation paused", "by", by)
+		if previous, ok := r.paused.Load(req.NamespacedName); !ok || previous != by {
+			r.paused.Store(req.NamespacedName, by)
+			r.Recorder.Eventf(&obj, corev1.EventTypeNormal, constants.EventReasonPaused,
+				"Reconciliation is paused by %s, remove the annotation %s to resume", by, constants.AnnotationPaused)
+		}
+		return reconcile.Result{RequeueAfter: r.ResyncInterval}, nil
+	}
+	r.paused.Delete(req.NamespacedName)
+
+	// create a helm client and inject context, logger and storage driver
+	helm, err := r.HelmFactory(req.Namespace, helm.WithContext(ctx), helm.WithLogger(logger), helm.WithHelmDriver(driver), helm.WithMaxHistory(maxHistory))
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 
-	// remove all dependencies form mapper
-	ref := mapper.ReferenceFromObject(&obj)
-	r.mapper.Graph.RemoveAllDependenciesFor(ref)
-
 	// handle finalizer during deletion
 	if !obj.ObjectMeta.DeletionTimestamp.IsZero() {
 		if contains(obj.ObjectMeta.Finalizers, constants.FinalizerName) {
-			// uninstall helm chart
-			_ = helm.Uninstall(req.Name)
+			// uninstall helm chart and keep the finalizer on failure unless its removal is forced
+			if err := helm.Uninstall(req.Name); err != nil {
+				if obj.Annotations[constants.AnnotationForceRemoveFinalizer] != "true" {
+					r.Recorder.Eventf(&obj, corev1.EventTypeWarning, constants.EventReasonUninstallFailed,
+						"Unable to uninstall helm release %s, set the annotation %s=true to remove the finalizer anyway: %v", req.Name, constants.AnnotationForceRemoveFinalizer, err)
+
+					// requeue the request with exponential backoff
+					return reconcile.Result{}, err
+				}
+
+				logger.Error(err, "unable to uninstall helm release, finalizer is removed by force")
+				r.Recorder.Eventf(&obj, corev1.EventTypeWarning, constants.EventReasonFinalizerForceRemoved,
+					"Finalizer removed without uninstalling helm release %s: %v", req.Name, err)
+			}
+
+			// remove the service accounts of the instance from its remote clusters
+			r.removeRemoteClusters(ctx, &obj)
 
 			// remove finalizer
 			obj.ObjectMeta.Finalizers = remove(obj.ObjectMeta.Finalizers, constants.FinalizerName)
-			if err := r.Update(ctx, &obj); err != nil {
+			if err := r.update(ctx, &obj); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
+		metrics.ForgetInstance(req.NamespacedName.String())
 		return reconcile.Result{}, nil
 	}
 
 	// add finalizer
 	if !contains(obj.ObjectMeta.Finalizers, constants.FinalizerName) {
 		obj.ObjectMeta.Finalizers = add(obj.ObjectMeta.Finalizers, constants.FinalizerName)
-		if err := r.Update(ctx, &obj); err != nil {
+		if err := r.update(ctx, &obj); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	// update image and version
-	if policy, ok := obj.Annotations[constants.AnnotationImageVersionUpdatePolicy]; ok && policy != constants.ImageVersionUpdatePolicyNone {
-		original := obj.Spec.DeepCopy()
+	// update image and version, the images which cannot be resolved are retried after the other steps
+	deferred, unresolved, err := r.updateImages(ctx, &obj)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
-		// set the ArgoCD image and version
-		if (policy == constants.ImageVersionUpdatePolicyAlways) ||
-			(obj.Spec.Image == "" && obj.Spec.Version == "" && policy == constants.ImageVersionUpdatePolicyIfNotPresent) {
-			if image := os.Getenv(constants.EnvArgoCDImage); image != "" {
-				split := strings.Split(image, ":")
-				if split[0] != "" {
-					obj.Spec.Image = split[0]
-				}
-				if len(split) > 1 {
-					obj.Spec.Version = split[1]
-				}
-			}
+	// load helm chart, the chart does not change without a restart
+	chart, err := r.loadChart(ctx)
+	if err != nil {
+		r.Recorder.Eventf(&obj, corev1.EventTypeWarning, constants.EventReasonChartLoadFailed, "Unable to load helm chart: %v", err)
+		return reconcile.Result{}, permanent(err)
+	}
+
+	// copy the default values of the chart instead of modifying them, they are part of the hash of the release values
+	values := chartutil.Values{}
+	for key, value := range chart.Values {
+		values[key] = value
+	}
+
+	// specify namespaces if the ArgoCD instance is not running in cluster mode
+	namespaces := []string{}
+	cluster := contains(strings.Split(os.Getenv(constants.EnvClusterArgoCDNamespacedNames), ","), req.NamespacedName.String())
+	if !cluster {
+		// load namespaces with matching labels
+		if namespaces, err = r.listNamespaces(ctx, req); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	values["namespaces"] = namespaces
+	if obj.Annotations[constants.AnnotationNetworkPolicies] == "true" {
+		// render the network policies of the blueprint for the ArgoCD namespace and, if enabled, the bound namespaces
+		values["networkPolicies"] = true
+		values["externalCIDR"] = r.NetworkPolicyExternalCIDR
+		values["clusterCIDRs"] = r.NetworkPolicyClusterCIDRs
+		if obj.Annotations[constants.AnnotationTenantNetworkPolicies] == "true" {
+			values["tenantNetworkPolicies"] = true
 		}
+	}
+	metrics.ObserveInstance(req.NamespacedName.String(), cluster, len(namespaces))
 
-		// set the ArgoCD Dex image and version
-		if (policy == constants.ImageVersionUpdatePolicyAlways) ||
-			(obj.Spec.Dex.Image == "" && obj.Spec.Dex.Version == "" && policy == constants.ImageVersionUpdatePolicyIfNotPresent) {
-			if image := os.Getenv(constants.EnvDexImage); image != "" {
-				split := strings.Split(image, ":")
-				if split[0] != "" {
-					obj.Spec.Dex.Image = split[0]
-				}
-				if len(split) > 1 {
-					obj.Spec.Dex.Version = split[1]
-				}
+	// only run helm upgrade if changes are needed
+	hash := utils.Hash(chart, values)
+	if value, ok := obj.Annotations[constants.AnnotationHelmHash]; !ok || value != hash {
+		metrics.HelmHashChecks.WithLabelValues(metrics.HashResultChanged).Inc()
+
+		// the instance may have been handed over to another replica during the reconciliation
+		if r.Sharder != nil && !r.Sharder.Owns(req.NamespacedName) {
+			return reconcile.Result{}, nil
+		}
+
+		// upgrade or install helm chart
+		if err = helm.Upgrade(req.Name, chart, values, true); err != nil {
+			return reconcile.Result{}, err
+		}
+	} else {
+		metrics.HelmHashChecks.WithLabelValues(metrics.HashResultSkipped).Inc()
+	}
+
+	// restrict the AppProjects to the bound namespaces, the projects of cluster mode instances are deleted since they
+	// are not bound to namespaces
+	if err = r.syncAppProjects(ctx, &obj, namespaces, cluster); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// derive the Argo CD RBAC policy from the RoleBindings of the bound namespaces
+	if !cluster {
+		if err = r.syncRBACPolicy(ctx, &obj, namespaces); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	// update helm hash and record the bound namespaces which are used by the mapper
+	if err = r.patchAnnotations(ctx, &obj, hash, namespaces); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// register the remote clusters after the bound namespaces were recorded, a failing cluster does not affect them
+	if err = r.syncRemoteClusters(ctx, &obj); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// retry the image updates which failed to resolve with backoff
+	if unresolved != nil {
+		return reconcile.Result{}, unresolved
+	}
+
+	// requeue deferred image updates when the maintenance window opens
+	if deferred > 0 && (r.ResyncInterval == 0 || deferred < r.ResyncInterval) {
+		return ctrl.Result{RequeueAfter: deferred}, nil
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+}
+
+// now returns the current time of the clock
+func (r *Reconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+// get retrieves the ArgoCD instance from the client
+func (r *Reconciler) get(ctx context.Context, key types.NamespacedName, obj *argoprojv1alpha1.ArgoCD) (err error) {
+	ctx, span := tracing.Start(ctx, "GetArgoCD")
+	defer func() { tracing.End(span, err) }()
+
+	return r.Get(ctx, key, obj)
+}
+
+// update updates the ArgoCD instance (e.g. the finalizers) with the client
+func (r *Reconciler) update(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) (err error) {
+	ctx, span := tracing.Start(ctx, "UpdateArgoCD")
+	defer func() { tracing.End(span, err) }()
+
+	return r.Update(ctx, obj)
+}
+
+// updateImages sets or updates the images and versions of the ArgoCD instance according to the update policy. Outside
+// the maintenance window the updates are deferred and the duration until the window opens is returned. The components
+// whose target image cannot be resolved are skipped, a transient error is returned as unresolved in order to retry them.
+func (r *Reconciler) updateImages(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) (deferred time.Duration, unresolved error, err error) {
+	ctx, span := tracing.Start(ctx, "UpdateImages")
+	defer func() { tracing.End(span, err) }()
+
+	if obj.Annotations[constants.AnnotationImageRevert] == "true" {
+		return 0, nil, r.revertImages(ctx, obj)
+	}
+
+	targets := imageTargets(obj)
+
+	// the policies are not applied while the image updates are paused (e.g. after a revert)
+	enabled := false
+	if obj.Annotations[constants.AnnotationImageUpdatesPaused] != "true" {
+		for _, t := range targets {
+			enabled = enabled || imagePolicy(obj, t.component) != constants.ImageVersionUpdatePolicyNone
+		}
+	}
+	if !enabled && !r.EnforceMirrors {
+		return 0, nil, nil
+	}
+
+	// an invalid maintenance window defers the image updates, the instance is reconciled anyway
+	windows, werr := maintenanceWindows(obj)
+	if werr != nil {
+		r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonInvalidMaintenanceWindow,
+			"Image updates are deferred until the maintenance window is fixed: %v", werr)
+	}
+
+	images, ok := targetImages(r.Catalog)
+	if !ok {
+		// the instances are enqueued once the catalog is loaded
+		return 0, nil, nil
+	}
+
+	original := obj.DeepCopy()
+
+	// each component is evaluated independently according to its own policy
+	var skipped []string
+	for _, t := range targets {
+		policy := imagePolicy(obj, t.component)
+		if !enabled || policy == constants.ImageVersionUpdatePolicyNone {
+			continue
+		}
+		if t.component == catalog.ComponentArgoCD && r.StagedRollout && policy == constants.ImageVersionUpdatePolicyAlways {
+			continue
+		}
+
+		// an invalid constraint only skips the update of its component, the instance is reconciled anyway
+		var constraint *semver.Constraints
+		if policy == constants.ImageVersionUpdatePolicySemver {
+			var cerr error
+			if constraint, cerr = imageConstraint(obj, t.component); cerr != nil {
+				r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonInvalidImageUpdateConstraint,
+					"Skipped image update of component %s: %v", t.component, cerr)
+				continue
 			}
 		}
 
-		// set the Redis image and version
-		if (policy == constants.ImageVersionUpdatePolicyAlways) ||
-			(obj.Spec.Redis.Image == "" && obj.Spec.Redis.Version == "" && policy == constants.ImageVersionUpdatePolicyIfNotPresent) {
-			if image := os.Getenv(constants.EnvRedisImage); image != "" {
-				split := strings.Split(image, ":")
-				if split[0] != "" {
-					obj.Spec.Redis.Image = split[0]
-				}
-				if len(split) > 1 {
-					obj.Spec.Redis.Version = split[1]
-				}
+		target, terr := r.targetImage(ctx, obj, policy, images[t.component])
+		if terr != nil {
+			r.Log.Error(terr, "unable to resolve target image", "argocd", types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}, "component", t.component)
+			if !IsPermanent(terr) {
+				unresolved = terr
 			}
+			continue
 		}
+		if image, ok := updateImage(policy, target, t.image, t.version, constraint); !ok {
+			skipped = append(skipped, image)
+		}
+	}
 
-		// create patch to set or update images and versions
-		patches, err := jsonpatch.CreateJSONPatch(&obj.Spec, original, jsonpatch.WithPrefix(jsonpatch.ParseJSONPointer("/spec")))
-		if err != nil {
-			return reconcile.Result{}, err
+	// record the skipped updates, an event is only created if they changed
+	if len(skipped) > 0 {
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
 		}
+		obj.Annotations[constants.AnnotationSkippedImageUpdates] = strings.Join(skipped, ",")
+		if original.Annotations[constants.AnnotationSkippedImageUpdates] != obj.Annotations[constants.AnnotationSkippedImageUpdates] {
+			r.Recorder.Eventf(obj, corev1.EventTypeNormal, constants.EventReasonImageUpdateSkipped,
+				"Skipped image updates not matching the semantic version policy: %s", strings.Join(skipped, ", "))
+		}
+	} else {
+		delete(obj.Annotations, constants.AnnotationSkippedImageUpdates)
+	}
 
-		// patch only if necessary in order to prevent endless reconcile loops between the extension and the actual argocd-operator
-		if patches.Len() > 0 {
-			if err = r.Patch(ctx, &obj, client.RawPatch(types.JSONPatchType, patches.Raw())); err != nil {
-				return reconcile.Result{}, err
+	// defer the updates of the images and versions until the maintenance window opens
+	if !reflect.DeepEqual(obj.Spec, original.Spec) {
+		if until, ok := windows.Until(r.now()); werr != nil || until > 0 || !ok {
+			obj.Spec = *original.Spec.DeepCopy()
+			if ok {
+				deferred = until
 			}
+			r.Log.Info("image update deferred until the maintenance window opens", "argocd", types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}, "after", deferred)
 		}
 	}
 
-	// load helm chart and update dependency
-	chart, err := loader.Load(os.Getenv(constants.EnvHelmDirectory))
+	// rewrite the images which were set by hand to the registry mirrors, regardless of the maintenance windows since
+	// the images cannot be pulled otherwise. Components without image would fall back to the default repository of the
+	// argocd-operator, hence its mirror is written explicitly.
+	if r.EnforceMirrors {
+		for _, t := range imageTargets(obj) {
+			repository := *t.image
+			if repository == "" {
+				repository = defaultImages[t.component]
+			}
+			if mirrored := r.Mirrors.Rewrite(image.Reference{Repository: repository}).Repository; mirrored != repository || *t.image != "" {
+				*t.image = mirrored
+			}
+		}
+	}
+
+	// record the previous images in order that they can be reverted
+	if !reflect.DeepEqual(obj.Spec, original.Spec) {
+		if err := recordImages(obj, original, r.now(), r.ImageHistoryLimit); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// create patch to set or update images and versions
+	patches, err := jsonpatch.CreateJSONPatch(obj, original)
 	if err != nil {
-		return reconcile.Result{}, err
+		return 0, nil, err
 	}
 
-	// load values from chart
-	values := chart.Values
-	if values == nil {
-		values = chartutil.Values{}
+	// patch only if necessary in order to prevent endless reconcile loops between the extension and the actual argocd-operator
+	if patches.Len() > 0 {
+		if err = r.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patches.Raw())); err != nil {
+			return 0, nil, err
+		}
+		if !reflect.DeepEqual(obj.Spec, original.Spec) {
+			metrics.ImageUpdatePatches.Inc()
+		}
 	}
 
-	// specify namespaces if the ArgoCD instance is not running in cluster mode
-	if !contains(strings.Split(os.Getenv(constants.EnvClusterArgoCDNamespacedNames), ","), req.NamespacedName.String()) {
-		// load namespaces with matching labels and update dependencies
-		namespaces := corev1.NamespaceList{}
-		if err := r.List(ctx, &namespaces, client.MatchingLabels{
-			constants.LabelArgoCDName:      req.Name,
-			constants.LabelArgoCDNamespace: req.Namespace,
-		}); err != nil {
-			return reconcile.Result{}, err
+	return deferred, unresolved, nil
+}
+
+// imageTarget is the image and version field of a component in the ArgoCD spec
+type imageTarget struct {
+	component catalog.Component
+	image     *string
+	version   *string
+}
+
+// defaultImages are the repositories the argocd-operator uses for the components without image
+var defaultImages = map[catalog.Component]string{
+	catalog.ComponentArgoCD:         common.ArgoCDDefaultArgoImage,
+	catalog.ComponentDex:            common.ArgoCDDefaultDexImage,
+	catalog.ComponentRedis:          common.ArgoCDDefaultRedisImage,
+	catalog.ComponentRedisHAProxy:   common.ArgoCDDefaultRedisHAProxyImage,
+	catalog.ComponentGrafana:        common.ArgoCDDefaultGrafanaImage,
+	catalog.ComponentApplicationSet: common.ArgoCDDefaultApplicationSetImage,
+}
+
+// imageTargets returns the image and version fields of the components of the instance, the optional components are
+// only included if they are enabled
+func imageTargets(obj *argoprojv1alpha1.ArgoCD) []imageTarget {
+	targets := []imageTarget{
+		{catalog.ComponentArgoCD, &obj.Spec.Image, &obj.Spec.Version},
+		{catalog.ComponentDex, &obj.Spec.Dex.Image, &obj.Spec.Dex.Version},
+		{catalog.ComponentRedis, &obj.Spec.Redis.Image, &obj.Spec.Redis.Version},
+	}
+	if obj.Spec.HA.Enabled {
+		targets = append(targets, imageTarget{catalog.ComponentRedisHAProxy, &obj.Spec.HA.RedisProxyImage, &obj.Spec.HA.RedisProxyVersion})
+	}
+	if obj.Spec.Grafana.Enabled {
+		targets = append(targets, imageTarget{catalog.ComponentGrafana, &obj.Spec.Grafana.Image, &obj.Spec.Grafana.Version})
+	}
+	if obj.Spec.ApplicationSet != nil {
+		targets = append(targets, imageTarget{catalog.ComponentApplicationSet, &obj.Spec.ApplicationSet.Image, &obj.Spec.ApplicationSet.Version})
+	}
+	return targets
+}
+
+// imagePolicy returns the update policy of the component, the annotation of the component overrides the one of the
+// instance (default: 'None')
+func imagePolicy(obj *argoprojv1alpha1.ArgoCD, component catalog.Component) string {
+	if policy, ok := obj.Annotations[constants.AnnotationImageVersionUpdatePolicy+"."+string(component)]; ok {
+		return policy
+	}
+	if policy, ok := obj.Annotations[constants.AnnotationImageVersionUpdatePolicy]; ok {
+		return policy
+	}
+	return constants.ImageVersionUpdatePolicyNone
+}
+
+// imageConstraint returns the semantic version constraint of the component for the 'Semver' policy, the constraint of
+// the instance only applies to the ArgoCD version since the other components are versioned independently
+func imageConstraint(obj *argoprojv1alpha1.ArgoCD, component catalog.Component) (*semver.Constraints, error) {
+	value, ok := obj.Annotations[constants.AnnotationImageVersionConstraint+"."+string(component)]
+	if !ok && component == catalog.ComponentArgoCD {
+		value = obj.Annotations[constants.AnnotationImageVersionConstraint]
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	constraint, err := semver.NewConstraint(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image update constraint '%s' of component '%s': %w", value, component, err)
+	}
+	return constraint, nil
+}
+
+// targetImages returns the target images of the catalog or, without a catalog, of the environment variables. It
+// returns false if the catalog was not loaded yet.
+func targetImages(c *catalog.Catalog) (catalog.Images, bool) {
+	if c == nil {
+		return catalog.FromEnv(), true
+	}
+	return c.Images()
+}
+
+// targetImage parses the target image of an environment variable and resolves it with the Resolver. The 'Semver'
+// policy only verifies the target since it compares tags, which cannot be derived from a digest.
+func (r *Reconciler) targetImage(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, policy string, value string) (image.Reference, error) {
+	if value == "" {
+		return image.Reference{}, nil
+	}
+
+	target, err := image.Parse(value)
+	if err != nil {
+		return image.Reference{}, permanent(err)
+	}
+	target = r.Mirrors.Rewrite(target)
+	if r.Resolver == nil || target.Repository == "" {
+		return target, nil
+	}
+
+	resolved, err := r.Resolver.Resolve(ctx, target)
+	if err != nil {
+		if image.IsNotFound(err) {
+			r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonImageVerificationFailed, "Image %s does not exist: %s", target, err)
+			return image.Reference{}, permanent(err)
 		}
+		return image.Reference{}, err
+	}
+	if policy == constants.ImageVersionUpdatePolicySemver {
+		return target, nil
+	}
+	return resolved, nil
+}
+
+// updateImage sets the image and version to the target according to the update policy, the version is the digest if
+// the target is pinned. It returns the target and false if the 'Semver' policy skipped an upgrade.
+func updateImage(policy string, target image.Reference, image *string, version *string, constraint *semver.Constraints) (string, bool) {
+	if target.String() == "" {
+		return "", true
+	}
 
-		var slice []string
-		for _, namespace := range namespaces.Items {
-			slice = append(slice, namespace.Name)
+	targetVersion := target.Version()
 
-			// add dependency
-			r.mapper.Graph.AddDependency(ref, mapper.ReferenceFromObject(&namespace))
+	switch policy {
+	case constants.ImageVersionUpdatePolicyAlways:
+	case constants.ImageVersionUpdatePolicyIfNotPresent:
+		if *image != "" || *version != "" {
+			return "", true
+		}
+	case constants.ImageVersionUpdatePolicySemver:
+		// semantic versions are tags, a pinned target is applied by its tag
+		targetVersion = target.Tag
+		upgrade, skip := semverUpgrade(*version, targetVersion, constraint)
+		if skip {
+			return target.String(), false
 		}
-		slice = add(slice, req.Namespace)
+		if !upgrade {
+			return "", true
+		}
+	default:
+		return "", true
+	}
 
-		// sort namespaces
-		sort.Strings(slice)
+	if target.Repository != "" {
+		*image = target.Repository
+	}
+	if targetVersion != "" {
+		*version = targetVersion
+	}
+	return "", true
+}
 
-		values["namespaces"] = slice
-	} else {
-		values["namespaces"] = []string{}
+// pausedBy returns who paused the instance: the value of the paused-by annotation or else the field manager which set
+// the paused annotation most recently
+func pausedBy(obj *argoprojv1alpha1.ArgoCD) string {
+	if by := obj.Annotations[constants.AnnotationPausedBy]; by != "" {
+		return by
 	}
 
-	// only run helm upgrade if changes are needed
-	hash := utils.Hash(chart, values)
-	if value, ok := obj.Annotations[constants.AnnotationHelmHash]; !ok || value != hash {
-		// upgrade or install helm chart
-		if err = helm.Upgrade(req.Name, chart, values, true); err != nil {
-			return reconcile.Result{}, err
+	by, last := "unknown", metav1.Time{}
+	field := strconv.Quote("f:" + constants.AnnotationPaused)
+	for _, entry := range obj.ManagedFields {
+		if entry.FieldsV1 == nil || !strings.Contains(string(entry.FieldsV1.Raw), field) {
+			continue
 		}
-
-		// update helm hash annotation
-		original := obj.ObjectMeta.DeepCopy()
-		if obj.ObjectMeta.Annotations == nil {
-			obj.ObjectMeta.Annotations = make(map[string]string)
+		if entry.Time == nil || !entry.Time.Before(&last) {
+			by = entry.Manager
+			if entry.Time != nil {
+				last = *entry.Time
+			}
 		}
-		obj.ObjectMeta.Annotations[constants.AnnotationHelmHash] = hash
+	}
+	return by
+}
 
-		patches, err := jsonpatch.CreateJSONPatch(&obj.ObjectMeta, original, jsonpatch.WithPrefix(jsonpatch.ParseJSONPointer("/metadata")))
+// maintenanceWindows returns the maintenance windows of an ArgoCD instance, the annotation overrides the global ones
+func maintenanceWindows(obj *argoprojv1alpha1.ArgoCD) (maintenance.Windows, error) {
+	window, ok := obj.Annotations[constants.AnnotationMaintenanceWindow]
+	if !ok {
+		window = os.Getenv(constants.EnvMaintenanceWindow)
+	}
+	return maintenance.Parse(window)
+}
+
+// semverUpgrade returns true if the target version is newer than the current one (or the current one is not set) and
+// satisfies the constraint. It never downgrades, skip is true if a newer or unknown version is not applied.
+func semverUpgrade(current string, target string, constraint *semver.Constraints) (upgrade bool, skip bool) {
+	t, err := semver.NewVersion(target)
+	if err != nil {
+		return false, true
+	}
+
+	if current != "" {
+		c, err := semver.NewVersion(current)
 		if err != nil {
-			return reconcile.Result{}, err
+			// the current version is not comparable (e.g. 'latest' or a digest)
+			return false, true
+		}
+		if !t.GreaterThan(c) {
+			return false, false
 		}
+	}
 
-		if patches.Len() > 0 {
-			if err = r.Patch(ctx, &obj, client.RawPatch(types.JSONPatchType, patches.Raw())); err != nil {
-				return reconcile.Result{}, err
-			}
+	if constraint != nil && !constraint.Check(t) {
+		return false, true
+	}
+	return true, false
+}
+
+// loadChart loads the helm chart from the helm directory
+func (r *Reconciler) loadChart(ctx context.Context) (_ *chart.Chart, err error) {
+	_, span := tracing.Start(ctx, "LoadChart")
+	defer func() { tracing.End(span, err) }()
+
+	chart, err := loader.Load(os.Getenv(constants.EnvHelmDirectory))
+	if err != nil {
+		metrics.ChartLoadFailures.Inc()
+		return nil, err
+	}
+
+	return chart, nil
+}
+
+// listNamespaces lists the sorted names of the namespaces bound to the ArgoCD instance (including its own namespace)
+func (r *Reconciler) listNamespaces(ctx context.Context, req ctrl.Request) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "ListNamespaces")
+	defer func() { tracing.End(span, err) }()
+
+	namespaces, err := mapper.ListBoundNamespaces(ctx, r, req.NamespacedName)
+	if err != nil {
+		return nil, err
+	}
+
+	var slice []string
+	for _, namespace := range namespaces {
+		slice = append(slice, namespace.Name)
+	}
+	slice = add(slice, req.Namespace)
+
+	// sort namespaces
+	sort.Strings(slice)
+
+	return slice, nil
+}
+
+// patchAnnotations sets the helm hash and the bound namespaces annotations of the ArgoCD instance
+func (r *Reconciler) patchAnnotations(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, hash string, namespaces []string) (err error) {
+	ctx, span := tracing.Start(ctx, "PatchAnnotations")
+	defer func() { tracing.End(span, err) }()
+
+	original := obj.ObjectMeta.DeepCopy()
+	if obj.ObjectMeta.Annotations == nil {
+		obj.ObjectMeta.Annotations = make(map[string]string)
+	}
+	obj.ObjectMeta.Annotations[constants.AnnotationHelmHash] = hash
+	if len(namespaces) > 0 {
+		obj.ObjectMeta.Annotations[constants.AnnotationNamespaces] = strings.Join(namespaces, ",")
+	} else {
+		delete(obj.ObjectMeta.Annotations, constants.AnnotationNamespaces)
+	}
+
+	patches, err := jsonpatch.CreateJSONPatch(&obj.ObjectMeta, original, jsonpatch.WithPrefix(jsonpatch.ParseJSONPointer("/metadata")))
+	if err != nil {
+		return err
+	}
+
+	if patches.Len() > 0 {
+		if err = r.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patches.Raw())); err != nil {
+			return err
 		}
 	}
 
-	return ctrl.Result{}, nil
+	return nil
 }
 
 // contains check if a string in a []string exists
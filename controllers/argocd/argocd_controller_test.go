@@ -15,17 +15,29 @@ import (
 	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
 	logr "github.com/go-logr/logr/testing"
 	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
 	"github.com/snorwin/argocd-operator-extension/pkg/constants"
 	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+	"github.com/snorwin/argocd-operator-extension/pkg/metrics"
 	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	"github.com/snorwin/argocd-operator-extension/pkg/sharding"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
 	"github.com/snorwin/argocd-operator-extension/pkg/utils"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
@@ -160,6 +172,40 @@ var _ = Describe("Reconciler", func() {
 			Ω(actual.Spec.Image).Should(Equal(image))
 			Ω(actual.Spec.Version).Should(Equal(tag))
 		})
+		It("should_leave_argocd_image_to_staged_rollout", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v1.2.3")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "latest",
+					Image:   "myargocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.StagedRollout = true
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Image).Should(Equal("myargocd"))
+			Ω(actual.Spec.Version).Should(Equal("latest"))
+		})
 		It("should_set_argocd_image_only", func() {
 			image := "argocd"
 
@@ -510,205 +556,1226 @@ var _ = Describe("Reconciler", func() {
 			Ω(actual.Spec.Redis.Image).Should(Equal(argocd.Spec.Redis.Image))
 			Ω(actual.Spec.Redis.Version).Should(Equal(tag))
 		})
-		It("should_not_upgrade_helm_chart_if_not_needed", func() {
-			chart, err := loader.Load(os.Getenv(constants.EnvHelmDirectory))
-			Ω(err).ShouldNot(HaveOccurred())
-
-			values := map[string]interface{}{
-				"namespaces": []string{"default"},
-			}
+		It("should_set_argocd_image_of_registry_with_port", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "registry:5000/argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
 
 			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "argocd",
 					Namespace: "default",
 					Annotations: map[string]string{
-						constants.AnnotationHelmHash: utils.Hash(chart, values),
-					},
-					ResourceVersion: "2",
-					Finalizers: []string{
-						constants.FinalizerName,
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
 					},
 				},
 			}
 
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
 			actual := testReconcile(mockHelm, argocd)
-			Ω(actual.ResourceVersion).Should(Equal(argocd.ResourceVersion))
+			Ω(actual.Spec.Image).Should(Equal("registry:5000/argoproj/argocd"))
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
 		})
-		It("should_upgrade_helm_chart_and_update_helm_hash", func() {
+		It("should_set_argocd_image_pinned_by_digest", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5@"+testDigest)).ShouldNot(HaveOccurred())
+
 			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "argocd",
 					Namespace: "default",
 					Annotations: map[string]string{
-						constants.AnnotationHelmHash: "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
-					},
-					ResourceVersion: "2",
-					Finalizers: []string{
-						constants.FinalizerName,
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
 					},
 				},
 			}
 
 			mockHelm.
 				EXPECT().
-				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
 				Return(nil)
 
 			actual := testReconcile(mockHelm, argocd)
-			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationHelmHash, Not(Equal(argocd.Annotations[constants.AnnotationHelmHash]))))
+			Ω(actual.Spec.Image).Should(Equal("argoproj/argocd"))
+			Ω(actual.Spec.Version).Should(Equal(testDigest))
 		})
+		It("should_resolve_argocd_image_to_digest", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
 
-		It("should_not_add_namespaces_for_cluster_argocd", func() {
 			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      "argocd",
-					Namespace: "default",
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
 				},
 			}
 
-			Ω(os.Setenv(constants.EnvClusterArgoCDNamespacedNames, fmt.Sprintf("%s/%s", argocd.Namespace, argocd.Name))).ShouldNot(HaveOccurred())
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Resolver = testResolver{"argoproj/argocd:v2.0.5": testDigest}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Image).Should(Equal("argoproj/argocd"))
+			Ω(actual.Spec.Version).Should(Equal(testDigest))
+		})
+		It("should_not_update_argocd_image_which_does_not_exist", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v9.9.9")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Image:   "argoproj/argocd",
+					Version: "v2.0.5",
+				},
+			}
 
+			// the instance is reconciled anyway
 			mockHelm.
 				EXPECT().
-				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{}), true).
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
 				Return(nil)
 
-			testReconcile(mockHelm, argocd)
+			r, recorder := testReconciler(mockHelm, argocd)
+			r.Resolver = testResolver{}
+			r.ResyncInterval = time.Hour
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(time.Hour))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonImageVerificationFailed)))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
 		})
-		It("should_include_labeled_namespaces", func() {
+		It("should_retry_image_update_after_transient_resolve_error", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.6")).ShouldNot(HaveOccurred())
+
 			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      "argocd",
-					Namespace: "default",
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Image:   "argoproj/argocd",
+					Version: "v2.0.5",
 				},
 			}
 
-			namespaces := []*corev1.Namespace{
-				{ObjectMeta: metav1.ObjectMeta{
-					Name: "myapp1",
-				}},
-				{ObjectMeta: metav1.ObjectMeta{
-					Name: "myapp2",
-				}},
-				{ObjectMeta: metav1.ObjectMeta{
-					Name: "myapp3",
-					Labels: map[string]string{
-						constants.LabelArgoCDName:      argocd.Name,
-						constants.LabelArgoCDNamespace: argocd.Namespace,
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Resolver = testUnavailableResolver{}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).Should(HaveOccurred())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+			Ω(actual.Annotations).Should(HaveKey(constants.AnnotationHelmHash))
+		})
+		It("should_set_images_of_enabled_components_from_catalog", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
 					},
-				}},
-				{ObjectMeta: metav1.ObjectMeta{
-					Name: "myapp4",
-					Labels: map[string]string{
-						constants.LabelArgoCDName:      argocd.Name,
-						constants.LabelArgoCDNamespace: argocd.Namespace,
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					HA:             argoprojv1alpha1.ArgoCDHASpec{Enabled: true},
+					ApplicationSet: &argoprojv1alpha1.ArgoCDApplicationSet{},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Catalog = &catalog.Catalog{Client: r.Client, Log: logr.NullLogger{}}
+			Ω(r.Catalog.Update(context.TODO(), &corev1.ConfigMap{Data: map[string]string{
+				"redis-ha-proxy": "haproxy:2.0.22-alpine",
+				"grafana":        "grafana/grafana:8.0.3",
+				"applicationset": "argoproj/applicationset:v0.2.0",
+			}})).ShouldNot(HaveOccurred())
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.HA.RedisProxyImage).Should(Equal("haproxy"))
+			Ω(actual.Spec.HA.RedisProxyVersion).Should(Equal("2.0.22-alpine"))
+			Ω(actual.Spec.ApplicationSet.Image).Should(Equal("argoproj/applicationset"))
+			Ω(actual.Spec.ApplicationSet.Version).Should(Equal("v0.2.0"))
+			// Grafana is not enabled
+			Ω(actual.Spec.Grafana.Image).Should(BeEmpty())
+		})
+		It("should_not_update_images_before_catalog_is_loaded", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
 					},
-				}},
+				},
 			}
 
 			mockHelm.
 				EXPECT().
-				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default", "myapp3", "myapp4"}), true).
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
 				Return(nil)
 
-			testReconcile(mockHelm, argocd, namespaces...)
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Catalog = &catalog.Catalog{Client: r.Client, Log: logr.NullLogger{}}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Image).Should(BeEmpty())
 		})
-		It("should_uninstall_helm_chart_if_argocd_was_deleted", func() {
+		It("should_evaluate_component_policies_independently", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+			Ω(os.Setenv(constants.EnvDexImage, "dex:v2.30.0")).ShouldNot(HaveOccurred())
+			Ω(os.Setenv(constants.EnvRedisImage, "redis:6.2.4")).ShouldNot(HaveOccurred())
+
 			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:              "argocd",
-					Namespace:         "default",
-					Finalizers:        []string{constants.FinalizerName},
-					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy:             constants.ImageVersionUpdatePolicyIfNotPresent,
+						constants.AnnotationImageVersionUpdatePolicy + ".argocd": constants.ImageVersionUpdatePolicyNone,
+						constants.AnnotationImageVersionUpdatePolicy + ".redis":  constants.ImageVersionUpdatePolicyAlways,
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Image:   "argocd",
+					Version: "v2.0.1",
+					Dex: argoprojv1alpha1.ArgoCDDexSpec{
+						Image:   "dex",
+						Version: "v2.28.1",
+					},
+					Redis: argoprojv1alpha1.ArgoCDRedisSpec{
+						Image:   "redis",
+						Version: "5.0.12",
+					},
 				},
 			}
 
 			mockHelm.
 				EXPECT().
-				Uninstall(argocd.Name).
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
 				Return(nil)
 
 			actual := testReconcile(mockHelm, argocd)
-			Ω(actual.Finalizers).ShouldNot(ContainElement(constants.FinalizerName))
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+			Ω(actual.Spec.Dex.Version).Should(Equal("v2.28.1"))
+			Ω(actual.Spec.Redis.Version).Should(Equal("6.2.4"))
 		})
-		It("should_ignore_uninstall_errors", func() {
+		It("should_apply_semver_constraint_of_component", func() {
+			Ω(os.Setenv(constants.EnvRedisImage, "redis:6.2.4")).ShouldNot(HaveOccurred())
+
 			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:              "argocd",
-					Namespace:         "default",
-					Finalizers:        []string{constants.FinalizerName},
-					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy + ".redis": constants.ImageVersionUpdatePolicySemver,
+						constants.AnnotationImageVersionConstraint + ".redis":   "~5.0",
+						// the constraint of the instance only applies to ArgoCD
+						constants.AnnotationImageVersionConstraint: "~6.2",
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Redis: argoprojv1alpha1.ArgoCDRedisSpec{
+						Image:   "redis",
+						Version: "5.0.12",
+					},
 				},
 			}
 
 			mockHelm.
 				EXPECT().
-				Uninstall(argocd.Name).
-				Return(errors.New("uninstall: Release not loaded"))
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
 
 			actual := testReconcile(mockHelm, argocd)
-			Ω(actual.Finalizers).ShouldNot(ContainElement(constants.FinalizerName))
+			Ω(actual.Spec.Redis.Version).Should(Equal("5.0.12"))
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationSkippedImageUpdates, "redis:6.2.4"))
 		})
-		It("should_nop_if_argocd_does_not_exist", func() {
-			s := scheme.Scheme
-			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
-			cl := client.NewFakeClientWithScheme(s)
+		It("should_rewrite_target_images_to_registry_mirror", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "quay.io/argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
+			Ω(os.Setenv(constants.EnvRedisImage, "redis:6.2.4")).ShouldNot(HaveOccurred())
 
-			r := &controller.Reconciler{
-				Client: cl,
-				Scheme: s,
-				Log:    logr.NullLogger{},
-				HelmFactory: func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
-					return mockHelm, nil
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
 				},
 			}
 
-			req := ctrl.Request{
-				NamespacedName: types.NamespacedName{Name: "argocd", Namespace: "default"},
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Mirrors = testMirrors()
+
+			actual := testReconcileWith(r, argocd)
+			Ω(actual.Spec.Image).Should(Equal("mirror.corp/quay/argoproj/argocd"))
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+			Ω(actual.Spec.Redis.Image).Should(Equal("mirror.corp/dockerhub/library/redis"))
+		})
+		It("should_enforce_registry_mirror_for_images_set_by_hand", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Image:   "quay.io/argoproj/argocd",
+					Version: "v2.0.1",
+					Dex: argoprojv1alpha1.ArgoCDDexSpec{
+						Image:   "ghcr.io/dexidp/dex",
+						Version: "v2.28.1",
+					},
+				},
 			}
 
-			result, err := r.Reconcile(req)
-			Ω(err).ShouldNot(HaveOccurred())
-			Ω(result).ShouldNot(BeNil())
-			Ω(result.Requeue).Should(BeFalse())
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Mirrors = testMirrors()
+			r.EnforceMirrors = true
+
+			actual := testReconcileWith(r, argocd)
+			Ω(actual.Spec.Image).Should(Equal("mirror.corp/quay/argoproj/argocd"))
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+			// no rule matches
+			Ω(actual.Spec.Dex.Image).Should(Equal("ghcr.io/dexidp/dex"))
+			// the default image of the argocd-operator is mirrored, the default version is kept
+			Ω(actual.Spec.Redis.Image).Should(Equal("mirror.corp/dockerhub/library/redis"))
+			Ω(actual.Spec.Redis.Version).Should(BeEmpty())
 		})
-	})
-})
+		It("should_record_previous_images_in_bounded_history", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
 
-func testReconcile(mockHelm *mock_helm.MockClient, argocd *argoprojv1alpha1.ArgoCD, namespaces ...*corev1.Namespace) *argoprojv1alpha1.ArgoCD {
-	s := scheme.Scheme
-	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+						constants.AnnotationImageHistory:             `[{"time":"2021-06-01T00:00:00Z","images":{"argocd":"argoproj/argocd:v1.8.7"}}]`,
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Image:   "argoproj/argocd",
+					Version: "v2.0.1",
+					Redis: argoprojv1alpha1.ArgoCDRedisSpec{
+						Image:   "redis",
+						Version: testDigest,
+					},
+				},
+			}
 
-	objects := []runtime.Object{argocd}
-	for _, namespace := range namespaces {
-		objects = append(objects, namespace)
-	}
-	cl := client.NewFakeClientWithScheme(s, objects...)
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
 
-	r := &controller.Reconciler{
-		Client: cl,
-		Scheme: s,
-		Log:    logr.NullLogger{},
-		HelmFactory: func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
-			return mockHelm, nil
-		},
-	}
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Clock = clock.NewFakeClock(time.Date(2021, time.June, 7, 12, 0, 0, 0, time.UTC))
+			r.ImageHistoryLimit = 1
 
-	req := ctrl.Request{
-		NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace},
-	}
+			actual := testReconcileWith(r, argocd)
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+			Ω(actual.Annotations[constants.AnnotationImageHistory]).Should(MatchJSON(
+				`[{"time":"2021-06-07T12:00:00Z","images":{"argocd":"argoproj/argocd:v2.0.1","dex":"","redis":"redis@` + testDigest + `"}}]`))
+		})
+		It("should_revert_images_and_pause_updates", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
 
-	result, err := r.Reconcile(req)
-	Ω(err).ShouldNot(HaveOccurred())
-	Ω(result).ShouldNot(BeNil())
-	Ω(result.Requeue).Should(BeFalse())
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+						constants.AnnotationImageRevert:              "true",
+						constants.AnnotationImageHistory: `[{"time":"2021-06-01T00:00:00Z","images":{"argocd":"argoproj/argocd:v1.8.7"}},` +
+							`{"time":"2021-06-07T00:00:00Z","images":{"argocd":"argoproj/argocd:v2.0.1"}}]`,
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Image:   "argoproj/argocd",
+					Version: "v2.0.5",
+				},
+			}
 
-	actual := &argoprojv1alpha1.ArgoCD{}
-	Ω(cl.Get(context.TODO(), req.NamespacedName, actual)).ShouldNot(HaveOccurred())
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, recorder := testReconciler(mockHelm, argocd)
+
+			actual := testReconcileWith(r, argocd)
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+			Ω(actual.Annotations).ShouldNot(HaveKey(constants.AnnotationImageRevert))
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationImageUpdatesPaused, "true"))
+			Ω(actual.Annotations[constants.AnnotationImageHistory]).Should(MatchJSON(`[{"time":"2021-06-01T00:00:00Z","images":{"argocd":"argoproj/argocd:v1.8.7"}}]`))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonImagesReverted)))
+
+			// the paused instance is not updated again, the helm chart is up to date
+			actual = testReconcileWith(r, argocd)
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+		})
+		It("should_revert_images_to_the_defaults_of_the_argocd_operator", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, recorder := testReconciler(mockHelm, argocd)
+
+			// the update from the default of the argocd-operator is recorded
+			actual := testReconcileWith(r, argocd)
+			Ω(actual.Spec.Image).Should(Equal("argoproj/argocd"))
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+
+			actual.Annotations[constants.AnnotationImageRevert] = "true"
+			Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+
+			actual = testReconcileWith(r, argocd)
+			Ω(actual.Spec.Image).Should(BeEmpty())
+			Ω(actual.Spec.Version).Should(BeEmpty())
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationImageUpdatesPaused, "true"))
+			Ω(actual.Annotations).ShouldNot(HaveKey(constants.AnnotationImageHistory))
+			Ω(recorder.Events).Should(Receive(ContainSubstring("default argocd")))
+		})
+		It("should_upgrade_argocd_version_within_semver_constraint", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicySemver,
+						constants.AnnotationImageVersionConstraint:   "~2.0",
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.1",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+			Ω(actual.Annotations).ShouldNot(HaveKey(constants.AnnotationSkippedImageUpdates))
+			Ω(recorder.Events).ShouldNot(Receive())
+		})
+		It("should_skip_argocd_version_outside_semver_constraint", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v3.0.0")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicySemver,
+						constants.AnnotationImageVersionConstraint:   "~2.0",
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.1",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationSkippedImageUpdates, "argocd:v3.0.0"))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonImageUpdateSkipped)))
+		})
+		It("should_never_downgrade_argocd_version_with_semver_policy", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.1")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicySemver,
+						constants.AnnotationImageVersionConstraint:   "~2.0",
+					},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.5",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+			Ω(actual.Annotations).ShouldNot(HaveKey(constants.AnnotationSkippedImageUpdates))
+			Ω(recorder.Events).ShouldNot(Receive())
+		})
+		It("should_skip_image_update_of_component_if_semver_constraint_is_invalid", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+			Ω(os.Setenv(constants.EnvDexImage, "dex:v2.28.1")).ShouldNot(HaveOccurred())
+			defer func() { Ω(os.Unsetenv(constants.EnvDexImage)).ShouldNot(HaveOccurred()) }()
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicySemver,
+						constants.AnnotationImageVersionConstraint:   "not a constraint",
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(BeEmpty())
+			Ω(actual.Spec.Dex.Version).Should(Equal("v2.28.1"))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonInvalidImageUpdateConstraint)))
+		})
+		It("should_defer_image_update_until_maintenance_window_opens", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+						constants.AnnotationMaintenanceWindow:        "Mon 22:00-23:00",
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.1",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			// 2021-06-07 is a Monday
+			r.Clock = clock.NewFakeClock(time.Date(2021, time.June, 7, 20, 0, 0, 0, time.UTC))
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(2 * time.Hour))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+		})
+		It("should_update_image_within_maintenance_window", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+						constants.AnnotationMaintenanceWindow:        "Mon 22:00-23:00",
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.1",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			// 2021-06-07 is a Monday
+			r.Clock = clock.NewFakeClock(time.Date(2021, time.June, 7, 22, 0, 0, 0, time.UTC))
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(time.Duration(0)))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.5"))
+		})
+		It("should_defer_image_update_if_maintenance_window_is_invalid", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+						constants.AnnotationMaintenanceWindow:        "Someday 22:00-23:00",
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.1",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonInvalidMaintenanceWindow)))
+		})
+		It("should_defer_image_update_until_global_maintenance_window_opens", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+			Ω(os.Setenv(constants.EnvMaintenanceWindow, "cron 0 21 * * 1 1h")).ShouldNot(HaveOccurred())
+			defer func() { Ω(os.Unsetenv(constants.EnvMaintenanceWindow)).ShouldNot(HaveOccurred()) }()
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+				Spec: argoprojv1alpha1.ArgoCDSpec{
+					Version: "v2.0.1",
+					Image:   "argocd",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			// 2021-06-07 is a Monday
+			r.Clock = clock.NewFakeClock(time.Date(2021, time.June, 7, 20, 0, 0, 0, time.UTC))
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(time.Hour))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Spec.Version).Should(Equal("v2.0.1"))
+		})
+		It("should_not_upgrade_helm_chart_if_not_needed", func() {
+			chart, err := loader.Load(os.Getenv(constants.EnvHelmDirectory))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			values := map[string]interface{}{
+				"namespaces": []string{"default"},
+			}
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationHelmHash:   utils.Hash(chart, values),
+						constants.AnnotationNamespaces: "default",
+					},
+					ResourceVersion: "2",
+					Finalizers: []string{
+						constants.FinalizerName,
+					},
+				},
+			}
+
+			skipped := testutil.ToFloat64(metrics.HelmHashChecks.WithLabelValues(metrics.HashResultSkipped))
+
+			actual := testReconcile(mockHelm, argocd)
+			Ω(actual.ResourceVersion).Should(Equal(argocd.ResourceVersion))
+			Ω(testutil.ToFloat64(metrics.HelmHashChecks.WithLabelValues(metrics.HashResultSkipped))).Should(Equal(skipped + 1))
+		})
+		It("should_upgrade_helm_chart_and_update_helm_hash", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationHelmHash: "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
+					},
+					ResourceVersion: "2",
+					Finalizers: []string{
+						constants.FinalizerName,
+					},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			actual := testReconcile(mockHelm, argocd)
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationHelmHash, Not(Equal(argocd.Annotations[constants.AnnotationHelmHash]))))
+		})
+
+		It("should_not_add_namespaces_for_cluster_argocd", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+				},
+			}
+
+			Ω(os.Setenv(constants.EnvClusterArgoCDNamespacedNames, fmt.Sprintf("%s/%s", argocd.Namespace, argocd.Name))).ShouldNot(HaveOccurred())
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{}), true).
+				Return(nil)
+
+			testReconcile(mockHelm, argocd)
+		})
+		It("should_include_labeled_namespaces", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+				},
+			}
+
+			namespaces := []*corev1.Namespace{
+				{ObjectMeta: metav1.ObjectMeta{
+					Name: "myapp1",
+				}},
+				{ObjectMeta: metav1.ObjectMeta{
+					Name: "myapp2",
+				}},
+				{ObjectMeta: metav1.ObjectMeta{
+					Name: "myapp3",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      argocd.Name,
+						constants.LabelArgoCDNamespace: argocd.Namespace,
+					},
+				}},
+				{ObjectMeta: metav1.ObjectMeta{
+					Name: "myapp4",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      argocd.Name,
+						constants.LabelArgoCDNamespace: argocd.Namespace,
+					},
+				}},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default", "myapp3", "myapp4"}), true).
+				Return(nil)
+
+			actual := testReconcile(mockHelm, argocd, namespaces...)
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationNamespaces, "default,myapp3,myapp4"))
+			Ω(testutil.ToFloat64(metrics.BoundNamespaces.WithLabelValues("default/argocd"))).Should(Equal(3.0))
+		})
+		It("should_not_return_permanent_error_but_resync_later", func() {
+			Ω(os.Setenv(constants.EnvHelmDirectory, "/does/not/exist")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			r, recorder := testReconciler(mockHelm, argocd)
+			r.ResyncInterval = 10 * time.Minute
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(10 * time.Minute))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonChartLoadFailed)))
+		})
+		It("should_return_transient_error", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(fmt.Errorf("connection refused"))
+
+			_, _, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).Should(HaveOccurred())
+			Ω(controller.IsPermanent(err)).Should(BeFalse())
+		})
+		It("should_skip_argocd_of_other_shard", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			r, _ := testReconciler(mockHelm, argocd)
+			// the sharder did not join the ring yet and therefore owns no instance
+			r.Sharder = &sharding.Sharder{Client: r.Client, Log: logr.NullLogger{}, Identity: "other", Namespace: "default"}
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result).Should(Equal(ctrl.Result{}))
+		})
+		It("should_reconcile_argocd_of_own_shard", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.Sharder = &sharding.Sharder{Client: r.Client, Log: logr.NullLogger{}, Identity: "self", Namespace: "default"}
+			Ω(r.Sharder.Sync(context.TODO())).ShouldNot(HaveOccurred())
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		It("should_resync_periodically", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "argocd",
+					Namespace:  "default",
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.ResyncInterval = time.Hour
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(time.Hour))
+		})
+		It("should_skip_all_mutations_of_paused_argocd", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+						constants.AnnotationPaused:                   "true",
+						constants.AnnotationPausedBy:                 "alice (incident 42)",
+					},
+				},
+			}
+
+			r, recorder := testReconciler(mockHelm, argocd)
+			r.ResyncInterval = time.Hour
+
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.RequeueAfter).Should(Equal(time.Hour))
+			Ω(recorder.Events).Should(Receive(And(ContainSubstring(constants.EventReasonPaused), ContainSubstring("alice (incident 42)"))))
+
+			// the event is only created once the pause starts
+			_, err = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(recorder.Events).ShouldNot(Receive())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Finalizers).Should(BeEmpty())
+			Ω(actual.Spec.Image).Should(BeEmpty())
+		})
+		It("should_keep_finalizer_of_deleted_paused_argocd", func() {
+			now := metav1.Now()
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "argocd",
+					Namespace:         "default",
+					Finalizers:        []string{constants.FinalizerName},
+					DeletionTimestamp: &now,
+					Annotations: map[string]string{
+						constants.AnnotationPaused: "true",
+					},
+					ManagedFields: []metav1.ManagedFieldsEntry{
+						{
+							Manager:  "kubectl-annotate",
+							FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:argocd.snorwin.io/paused":{}}}}`)},
+						},
+					},
+				},
+			}
+
+			r, recorder := testReconciler(mockHelm, argocd)
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(recorder.Events).Should(Receive(ContainSubstring("kubectl-annotate")))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Finalizers).Should(ConsistOf(constants.FinalizerName))
+		})
+		It("should_uninstall_helm_chart_if_argocd_was_deleted", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "argocd",
+					Namespace:         "default",
+					Finalizers:        []string{constants.FinalizerName},
+					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Uninstall(argocd.Name).
+				Return(nil)
+
+			actual := testReconcile(mockHelm, argocd)
+			Ω(actual.Finalizers).ShouldNot(ContainElement(constants.FinalizerName))
+		})
+		It("should_keep_finalizer_if_uninstall_fails", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "argocd",
+					Namespace:         "default",
+					Finalizers:        []string{constants.FinalizerName},
+					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Uninstall(argocd.Name).
+				Return(errors.New("uninstall: Release not loaded"))
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).Should(HaveOccurred())
+			Ω(actual.Finalizers).Should(ContainElement(constants.FinalizerName))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonUninstallFailed)))
+		})
+		It("should_force_remove_finalizer_if_uninstall_fails", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "argocd",
+					Namespace:         "default",
+					Finalizers:        []string{constants.FinalizerName},
+					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+					Annotations: map[string]string{
+						constants.AnnotationForceRemoveFinalizer: "true",
+					},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Uninstall(argocd.Name).
+				Return(errors.New("uninstall: Release not loaded"))
+
+			actual, recorder, err := testReconcileWithError(mockHelm, argocd)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(actual.Finalizers).ShouldNot(ContainElement(constants.FinalizerName))
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonFinalizerForceRemoved)))
+		})
+		It("should_trace_reconcile_phases", func() {
+			exporter := tracetest.NewInMemoryExporter()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+			defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("namespaces", []string{"default"}), true).
+				Return(nil)
+
+			testReconcile(mockHelm, argocd)
+
+			var names []string
+			for _, span := range exporter.GetSpans() {
+				names = append(names, span.Name)
+				if span.Name == "Reconcile" {
+					Ω(span.Attributes).Should(ContainElement(tracing.ArgoCD("default/argocd")))
+				}
+			}
+			Ω(names).Should(ConsistOf("Reconcile", "GetArgoCD", "UpdateArgoCD", "UpdateImages", "LoadChart", "ListNamespaces", "SyncAppProjects", "SyncRBACPolicy", "PatchAnnotations"))
+		})
+		It("should_render_network_policies_if_enabled", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNetworkPolicies: "true",
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), gomock.All(
+					Values("networkPolicies", true),
+					Values("clusterCIDRs", []string{"10.0.0.0/8"}),
+					Values("tenantNetworkPolicies", nil),
+				), true).
+				Return(nil)
+
+			r, _ := testReconciler(mockHelm, argocd)
+			r.NetworkPolicyClusterCIDRs = []string{"10.0.0.0/8"}
+			testReconcileWith(r, argocd)
+		})
+		It("should_render_tenant_network_policies_if_enabled", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNetworkPolicies:       "true",
+						constants.AnnotationTenantNetworkPolicies: "true",
+					},
+					Finalizers: []string{constants.FinalizerName},
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				Upgrade(argocd.Name, gomock.Any(), Values("tenantNetworkPolicies", true), true).
+				Return(nil)
+
+			testReconcile(mockHelm, argocd)
+		})
+		It("should_nop_if_argocd_does_not_exist", func() {
+			s := scheme.Scheme
+			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			cl := client.NewFakeClientWithScheme(s)
+
+			r := &controller.Reconciler{
+				Client:   cl,
+				Scheme:   s,
+				Log:      logr.NullLogger{},
+				Recorder: record.NewFakeRecorder(10),
+				HelmFactory: func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
+					return mockHelm, nil
+				},
+			}
+
+			req := ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: "argocd", Namespace: "default"},
+			}
+
+			result, err := r.Reconcile(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result).ShouldNot(BeNil())
+			Ω(result.Requeue).Should(BeFalse())
+		})
+	})
+})
+
+func testReconcile(mockHelm *mock_helm.MockClient, argocd *argoprojv1alpha1.ArgoCD, namespaces ...*corev1.Namespace) *argoprojv1alpha1.ArgoCD {
+	actual, _, err := testReconcileWithError(mockHelm, argocd, namespaces...)
+	Ω(err).ShouldNot(HaveOccurred())
+	return actual
+}
+
+func testReconcileWithError(mockHelm *mock_helm.MockClient, argocd *argoprojv1alpha1.ArgoCD, namespaces ...*corev1.Namespace) (*argoprojv1alpha1.ArgoCD, *record.FakeRecorder, error) {
+	objects := []runtime.Object{argocd}
+	for _, namespace := range namespaces {
+		objects = append(objects, namespace)
+	}
+	r, recorder := testReconciler(mockHelm, objects...)
+
+	req := ctrl.Request{
+		NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace},
+	}
+
+	result, err := r.Reconcile(req)
+	Ω(result).ShouldNot(BeNil())
+	Ω(result.Requeue).Should(BeFalse())
+
+	actual := &argoprojv1alpha1.ArgoCD{}
+	Ω(r.Get(context.TODO(), req.NamespacedName, actual)).ShouldNot(HaveOccurred())
+	return actual, recorder, err
+}
+
+func testReconciler(mockHelm *mock_helm.MockClient, objects ...runtime.Object) (*controller.Reconciler, *record.FakeRecorder) {
+	s := scheme.Scheme
+	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+	recorder := record.NewFakeRecorder(10)
+	return &controller.Reconciler{
+		Client:   client.NewFakeClientWithScheme(s, objects...),
+		Scheme:   s,
+		Log:      logr.NullLogger{},
+		Recorder: recorder,
+		HelmFactory: func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
+			return mockHelm, nil
+		},
+	}, recorder
+}
+
+func testReconcileWith(r *controller.Reconciler, argocd *argoprojv1alpha1.ArgoCD) *argoprojv1alpha1.ArgoCD {
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+	Ω(err).ShouldNot(HaveOccurred())
+
+	actual := &argoprojv1alpha1.ArgoCD{}
+	Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
 	return actual
 }
 
+func testMirrors() image.Rules {
+	rules, err := image.ParseRules("quay.io/=mirror.corp/quay/,docker.io/=mirror.corp/dockerhub/")
+	Ω(err).ShouldNot(HaveOccurred())
+	return rules
+}
+
+const testDigest = "sha256:4d70e3f8e9a7b4cf5c1f3ff2e0f4d3bdbd5f0e8b1d22c6e6fca7b8a9a6c5e4d3"
+
+// testResolver resolves the references (<repository>:<tag>) to the digests of the map
+type testResolver map[string]string
+
+func (t testResolver) Resolve(_ context.Context, ref image.Reference) (image.Reference, error) {
+	d, ok := t[ref.Repository+":"+ref.Tag]
+	if !ok {
+		return image.Reference{}, fmt.Errorf("unable to resolve image '%s': %w", ref, image.ErrNotFound)
+	}
+	return image.Reference{Repository: ref.Repository, Tag: ref.Tag, Digest: d}, nil
+}
+
+// testUnavailableResolver fails to resolve any reference like an unavailable registry
+type testUnavailableResolver struct{}
+
+func (testUnavailableResolver) Resolve(_ context.Context, ref image.Reference) (image.Reference, error) {
+	return image.Reference{}, fmt.Errorf("unable to resolve image '%s': %w", ref, context.DeadlineExceeded)
+}
+
 func Values(key string, value interface{}) gomock.Matcher {
 	return valuesMatcher{key, value}
 }
@@ -1,13 +1,24 @@
 package argocd_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
 )
 
 func TestArgocd(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "ArgoCD Controller Suite")
 }
+
+// testHelmDirectory sets the directory of the blueprint chart which is loaded by the reconciler
+func testHelmDirectory() {
+	wd, err := os.Getwd()
+	Ω(err).ShouldNot(HaveOccurred())
+	Ω(os.Setenv(constants.EnvHelmDirectory, filepath.Join(wd, "/../../helm/charts/argocd-operator-extension/resources"))).ShouldNot(HaveOccurred())
+}
@@ -0,0 +1,100 @@
+package argocd_test
+
+import (
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("Blueprint", func() {
+	BeforeEach(func() {
+		testHelmDirectory()
+	})
+	It("should_not_render_network_policies_by_default", func() {
+		Ω(testNetworkPolicies(map[string]interface{}{
+			"namespaces": []string{"argocd", "myapp"},
+		})).Should(BeEmpty())
+	})
+	It("should_not_render_egress_policy_without_cluster_cidrs", func() {
+		Ω(testNetworkPolicies(map[string]interface{}{
+			"namespaces":      []string{"argocd", "myapp"},
+			"networkPolicies": true,
+			"externalCIDR":    "0.0.0.0/0",
+		})).Should(BeEmpty())
+	})
+	It("should_render_network_policies_for_bound_namespaces", func() {
+		policies := testNetworkPolicies(map[string]interface{}{
+			"namespaces":            []string{"argocd", "myapp1", "myapp2"},
+			"networkPolicies":       true,
+			"tenantNetworkPolicies": true,
+			"externalCIDR":          "0.0.0.0/0",
+			"clusterCIDRs":          []string{"10.0.0.0/8"},
+		})
+
+		var names []string
+		for _, policy := range policies {
+			names = append(names, policy.Namespace+"/"+policy.Name)
+		}
+		Ω(names).Should(ConsistOf("argocd/myargocd-argocd-egress", "myapp1/argocd-myargocd-ingress", "myapp2/argocd-myargocd-ingress"))
+
+		for _, policy := range policies {
+			if policy.Namespace == "argocd" {
+				Ω(policy.Spec.PolicyTypes).Should(ConsistOf(networkingv1.PolicyTypeEgress))
+				Ω(policy.Spec.PodSelector.MatchExpressions[0].Values).Should(ConsistOf("myargocd-application-controller", "myargocd-repo-server"))
+				Ω(policy.Spec.Egress[1].To[0].NamespaceSelector.MatchLabels).Should(Equal(map[string]string{
+					constants.LabelArgoCDName:      "myargocd",
+					constants.LabelArgoCDNamespace: "argocd",
+				}))
+				Ω(policy.Spec.Egress[3].To[0].IPBlock.CIDR).Should(Equal("0.0.0.0/0"))
+				Ω(policy.Spec.Egress[3].To[0].IPBlock.Except).Should(ConsistOf("10.0.0.0/8"))
+			} else {
+				Ω(policy.Spec.PolicyTypes).Should(ConsistOf(networkingv1.PolicyTypeIngress))
+				Ω(policy.Spec.Ingress[1].From[0].NamespaceSelector.MatchLabels).Should(HaveKeyWithValue("kubernetes.io/metadata.name", "argocd"))
+			}
+		}
+	})
+	It("should_not_isolate_bound_namespaces_without_tenant_network_policies", func() {
+		policies := testNetworkPolicies(map[string]interface{}{
+			"namespaces":      []string{"argocd", "myapp"},
+			"networkPolicies": true,
+			"clusterCIDRs":    []string{"10.0.0.0/8"},
+		})
+
+		Ω(policies).Should(HaveLen(1))
+		Ω(policies[0].Namespace + "/" + policies[0].Name).Should(Equal("argocd/myargocd-argocd-egress"))
+	})
+})
+
+// testNetworkPolicies renders the blueprint chart as release 'myargocd' in the namespace 'argocd' and returns its
+// network policies
+func testNetworkPolicies(values map[string]interface{}) []networkingv1.NetworkPolicy {
+	chart, err := loader.Load(os.Getenv(constants.EnvHelmDirectory))
+	Ω(err).ShouldNot(HaveOccurred())
+
+	renderValues, err := chartutil.ToRenderValues(chart, values, chartutil.ReleaseOptions{Name: "myargocd", Namespace: "argocd"}, nil)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	manifests, err := engine.Render(chart, renderValues)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	var ret []networkingv1.NetworkPolicy
+	for _, manifest := range manifests {
+		for _, document := range strings.Split(manifest, "\n---") {
+			policy := networkingv1.NetworkPolicy{}
+			Ω(yaml.Unmarshal([]byte(document), &policy)).ShouldNot(HaveOccurred())
+			if policy.Kind == "NetworkPolicy" {
+				ret = append(ret, policy)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,35 @@
+package argocd
+
+import (
+	"errors"
+)
+
+// PermanentError marks a reconcile error which is not resolved by retrying, e.g. an invalid helm chart. The request
+// is not requeued with backoff but only retried after the resync interval.
+type PermanentError struct {
+	Err error
+}
+
+// Error implements the error interface
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// permanent wraps an error as PermanentError, nil is returned as it is
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent returns true if the error or any error it wraps is a PermanentError
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
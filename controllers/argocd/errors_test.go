@@ -0,0 +1,21 @@
+package argocd_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	controller "github.com/snorwin/argocd-operator-extension/controllers/argocd"
+)
+
+var _ = Describe("PermanentError", func() {
+	It("should_detect_wrapped_permanent_errors", func() {
+		err := &controller.PermanentError{Err: fmt.Errorf("invalid chart")}
+
+		Ω(controller.IsPermanent(err)).Should(BeTrue())
+		Ω(controller.IsPermanent(fmt.Errorf("reconcile failed: %w", err))).Should(BeTrue())
+		Ω(controller.IsPermanent(fmt.Errorf("connection refused"))).Should(BeFalse())
+		Ω(controller.IsPermanent(nil)).Should(BeFalse())
+	})
+})
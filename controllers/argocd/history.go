@@ -0,0 +1,150 @@
+package argocd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	"github.com/snorwin/jsonpatch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+// defaultImageHistoryLimit - number of history entries which are kept if no limit is set
+const defaultImageHistoryLimit = 10
+
+// imageHistoryEntry records the images of the components before an update of the extension
+type imageHistoryEntry struct {
+	// Time of the update
+	Time time.Time `json:"time"`
+	// Images of the components ([<image>][:<tag>|@<digest>]) before the update
+	Images catalog.Images `json:"images"`
+}
+
+// imageHistory returns the history entries of the instance, the latest entry is the last one
+func imageHistory(obj *argoprojv1alpha1.ArgoCD) ([]imageHistoryEntry, error) {
+	value, ok := obj.Annotations[constants.AnnotationImageHistory]
+	if !ok {
+		return nil, nil
+	}
+
+	var ret []imageHistoryEntry
+	if err := json.Unmarshal([]byte(value), &ret); err != nil {
+		return nil, fmt.Errorf("invalid image history: %w", err)
+	}
+	return ret, nil
+}
+
+// setImageHistory stores the history entries in the annotation, only the latest entries up to the limit are kept
+func setImageHistory(obj *argoprojv1alpha1.ArgoCD, history []imageHistoryEntry, limit int) error {
+	if len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	if len(history) == 0 {
+		delete(obj.Annotations, constants.AnnotationImageHistory)
+		return nil
+	}
+
+	value, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if obj.Annotations == nil {
+		obj.Annotations = make(map[string]string)
+	}
+	obj.Annotations[constants.AnnotationImageHistory] = string(value)
+	return nil
+}
+
+// recordImages appends the images of the original instance to the history of the updated one, an invalid history is
+// replaced instead of blocking the updates. Components without image and version are recorded with an empty value in
+// order that the default of the argocd-operator is restored by a revert.
+func recordImages(obj, original *argoprojv1alpha1.ArgoCD, now time.Time, limit int) error {
+	history, err := imageHistory(obj)
+	if err != nil {
+		history = nil
+	}
+
+	images := catalog.Images{}
+	for _, t := range imageTargets(original) {
+		images[t.component] = specImage(*t.image, *t.version)
+	}
+
+	return setImageHistory(obj, append(history, imageHistoryEntry{Time: now.UTC(), Images: images}), imageHistoryLimit(limit))
+}
+
+// revertImages restores the images of the latest history entry, removes the entry and pauses the image updates
+func (r *Reconciler) revertImages(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) (err error) {
+	ctx, span := tracing.Start(ctx, "RevertImages")
+	defer func() { tracing.End(span, err) }()
+
+	original := obj.DeepCopy()
+	delete(obj.Annotations, constants.AnnotationImageRevert)
+
+	history, err := imageHistory(obj)
+	if err != nil {
+		return permanent(err)
+	}
+	if len(history) == 0 {
+		r.Recorder.Event(obj, corev1.EventTypeWarning, constants.EventReasonImagesReverted, "Unable to revert images, the image history is empty")
+	} else {
+		latest := history[len(history)-1]
+		var reverted []string
+		for _, t := range imageTargets(obj) {
+			value, ok := latest.Images[t.component]
+			if !ok {
+				continue
+			}
+			if value == "" {
+				*t.image, *t.version = "", ""
+				reverted = append(reverted, "default "+string(t.component))
+				continue
+			}
+			ref, err := image.Parse(value)
+			if err != nil {
+				return permanent(err)
+			}
+			*t.image, *t.version = ref.Repository, ref.Version()
+			reverted = append(reverted, value)
+		}
+		if err := setImageHistory(obj, history[:len(history)-1], imageHistoryLimit(r.ImageHistoryLimit)); err != nil {
+			return err
+		}
+
+		obj.Annotations[constants.AnnotationImageUpdatesPaused] = "true"
+		r.Recorder.Eventf(obj, corev1.EventTypeNormal, constants.EventReasonImagesReverted,
+			"Reverted images to %s from %s, the image updates are paused", strings.Join(reverted, ", "), latest.Time.Format(time.RFC3339))
+	}
+
+	patches, err := jsonpatch.CreateJSONPatch(obj, original)
+	if err != nil {
+		return err
+	}
+	return r.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patches.Raw()))
+}
+
+func imageHistoryLimit(limit int) int {
+	if limit > 0 {
+		return limit
+	}
+	return defaultImageHistoryLimit
+}
+
+// specImage combines the image and version of a component the same way as the argocd-operator
+func specImage(image, version string) string {
+	if strings.Contains(version, ":") {
+		return image + "@" + version
+	} else if version != "" {
+		return image + ":" + version
+	}
+	return image
+}
@@ -0,0 +1,48 @@
+package argocd
+
+import (
+	"context"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+// MigrateHelmDriver moves the Helm releases of all ArgoCD instances from the storage driver 'from' to the configured
+// storage driver (HELM_DRIVER). If dryRun is set the releases are only reported and nothing is moved.
+func (r *Reconciler) MigrateHelmDriver(ctx context.Context, reader client.Reader, from string, dryRun bool, opts ...client.ListOption) error {
+	// set default factory if it was not set before
+	if r.HelmFactory == nil {
+		r.HelmFactory = helm.NewClientForNamespace
+	}
+
+	list := argoprojv1alpha1.ArgoCDList{}
+	if err := reader.List(ctx, &list, opts...); err != nil {
+		return err
+	}
+
+	// an instance which cannot be migrated does not prevent the migration of the others
+	var errs []error
+	for _, obj := range list.Items {
+		logger := r.Log.WithValues("argocd", types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name})
+
+		helm, err := r.HelmFactory(obj.Namespace, helm.WithLogger(logger), helm.WithHelmDriver(driver), helm.WithMaxHistory(maxHistory))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		revisions, err := helm.Migrate(obj.Name, from, dryRun)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		logger.Info("migrate helm release", "from", from, "to", driver, "revisions", revisions, "dryRun", dryRun)
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,110 @@
+package argocd_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	logr "github.com/go-logr/logr/testing"
+	"github.com/golang/mock/gomock"
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	controller "github.com/snorwin/argocd-operator-extension/controllers/argocd"
+)
+
+var _ = Describe("Migrate", func() {
+	Context("MigrateHelmDriver", func() {
+		var (
+			mockCtrl *gomock.Controller
+			mockHelm *mock_helm.MockClient
+			r        *controller.Reconciler
+		)
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockHelm = mock_helm.NewMockClient(mockCtrl)
+
+			r = &controller.Reconciler{
+				Log: logr.NullLogger{},
+				HelmFactory: func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
+					return mockHelm, nil
+				},
+			}
+		})
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+		It("should_migrate_release_of_every_argocd", func() {
+			s := scheme.Scheme
+			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			cl := client.NewFakeClientWithScheme(s,
+				&argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd1", Namespace: "default"}},
+				&argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd2", Namespace: "other"}},
+			)
+
+			mockHelm.
+				EXPECT().
+				Migrate("argocd1", "configmap", false).
+				Return(2, nil)
+			mockHelm.
+				EXPECT().
+				Migrate("argocd2", "configmap", false).
+				Return(0, nil)
+
+			Ω(r.MigrateHelmDriver(context.TODO(), cl, "configmap", false)).ShouldNot(HaveOccurred())
+		})
+		It("should_pass_dry_run", func() {
+			s := scheme.Scheme
+			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			cl := client.NewFakeClientWithScheme(s,
+				&argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd", Namespace: "default"}},
+			)
+
+			mockHelm.
+				EXPECT().
+				Migrate("argocd", "configmap", true).
+				Return(1, nil)
+
+			Ω(r.MigrateHelmDriver(context.TODO(), cl, "configmap", true)).ShouldNot(HaveOccurred())
+		})
+		It("should_return_migration_errors", func() {
+			s := scheme.Scheme
+			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			cl := client.NewFakeClientWithScheme(s,
+				&argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd", Namespace: "default"}},
+			)
+
+			mockHelm.
+				EXPECT().
+				Migrate("argocd", "memory", false).
+				Return(0, errors.New("unable to migrate release argocd: the 'memory' storage driver is not persistent"))
+
+			Ω(r.MigrateHelmDriver(context.TODO(), cl, "memory", false)).Should(HaveOccurred())
+		})
+		It("should_migrate_remaining_releases_if_a_migration_fails", func() {
+			s := scheme.Scheme
+			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			cl := client.NewFakeClientWithScheme(s,
+				&argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd1", Namespace: "default"}},
+				&argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd2", Namespace: "default"}},
+			)
+
+			mockHelm.
+				EXPECT().
+				Migrate("argocd1", "configmap", false).
+				Return(0, errors.New("connection refused"))
+			mockHelm.
+				EXPECT().
+				Migrate("argocd2", "configmap", false).
+				Return(1, nil)
+
+			Ω(r.MigrateHelmDriver(context.TODO(), cl, "configmap", false)).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,17 @@
+package argocd
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// NewRateLimiter creates a rate limiter for the reconcile requests which combines an exponential backoff per ArgoCD
+// instance (from baseDelay to maxDelay) with a global token bucket (qps and burst) for all the instances
+func NewRateLimiter(baseDelay, maxDelay time.Duration, qps float64, burst int) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
@@ -0,0 +1,152 @@
+package argocd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/policy"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+const (
+	// rbacConfigMapName - name of the ConfigMap of the Argo CD RBAC which is created by the argocd-operator
+	rbacConfigMapName = "argocd-rbac-cm"
+	// rbacConfigMapPolicyKey - key of the policy CSV in the RBAC ConfigMap
+	rbacConfigMapPolicyKey = "policy.csv"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;update;patch
+
+// syncRBACPolicy generates the Argo CD RBAC policy lines from the RoleBindings of the bound namespaces and writes them
+// to the target of the annotation, the lines outside the generated block are kept. The generated block is removed if
+// the annotation is removed or the policy is disabled.
+func (r *Reconciler) syncRBACPolicy(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, namespaces []string) (err error) {
+	ctx, span := tracing.Start(ctx, "SyncRBACPolicy")
+	defer func() { tracing.End(span, err) }()
+
+	target, ok := obj.Annotations[constants.AnnotationRBACPolicy]
+	if !ok || r.RBACPolicyRoles == nil {
+		return r.removeRBACPolicy(ctx, obj)
+	}
+
+	var lines []string
+	for _, namespace := range namespaces {
+		list := rbacv1.RoleBindingList{}
+		if err := r.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		lines = append(lines, policy.Generate(namespace, list.Items, r.RBACPolicyRoles)...)
+	}
+
+	switch target {
+	case constants.RBACPolicyTargetSpec:
+		return r.patchRBACPolicy(ctx, obj, lines)
+	case constants.RBACPolicyTargetConfigMap:
+		return r.updateRBACConfigMap(ctx, obj, lines)
+	default:
+		r.Log.Info("ignore unknown RBAC policy target", "argocd", types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name}, "target", target)
+		return nil
+	}
+}
+
+// removeRBACPolicy removes the generated block from both targets since the last one is unknown, otherwise the groups
+// keep the access they were granted. Policies without the block are left untouched.
+func (r *Reconciler) removeRBACPolicy(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) error {
+	if obj.Spec.RBAC.Policy != nil && strings.Contains(*obj.Spec.RBAC.Policy, policy.BeginMarker) {
+		if err := r.patchRBACPolicy(ctx, obj, nil); err != nil {
+			return err
+		}
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: rbacConfigMapName}, cm); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !strings.Contains(cm.Data[rbacConfigMapPolicyKey], policy.BeginMarker) {
+		return nil
+	}
+	return r.updateRBACConfigMap(ctx, obj, nil)
+}
+
+// patchRBACPolicy merges the lines into the policy of the ArgoCD spec, the argocd-operator writes it to its ConfigMap
+func (r *Reconciler) patchRBACPolicy(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, lines []string) error {
+	current := ""
+	if obj.Spec.RBAC.Policy != nil {
+		current = *obj.Spec.RBAC.Policy
+	}
+
+	merged := policy.Merge(current, lines)
+	if (obj.Spec.RBAC.Policy != nil && merged == current) || (obj.Spec.RBAC.Policy == nil && merged == "") {
+		return nil
+	}
+
+	// the argocd-operator ignores a nil policy, hence an empty policy is set explicitly with a merge patch in order
+	// that it clears the ConfigMap once all the generated lines are removed
+	original := obj.DeepCopy()
+	obj.Spec.RBAC.Policy = &merged
+	return r.Patch(ctx, obj, client.MergeFrom(original))
+}
+
+// updateRBACConfigMap merges the lines into the policy of the RBAC ConfigMap, it is updated once the argocd-operator
+// created it
+func (r *Reconciler) updateRBACConfigMap(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, lines []string) error {
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: rbacConfigMapName}, cm); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	current := cm.Data[rbacConfigMapPolicyKey]
+	merged := policy.Merge(current, lines)
+	if merged == current {
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[rbacConfigMapPolicyKey] = merged
+	return r.Update(ctx, cm)
+}
+
+// groupBindingsChanged returns a predicate which ignores the RoleBindings without groups (e.g. the ones of the service
+// accounts created by the helm chart)
+func groupBindingsChanged() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(evt event.CreateEvent) bool {
+			return bindsGroups(evt.Object)
+		},
+		UpdateFunc: func(evt event.UpdateEvent) bool {
+			return bindsGroups(evt.ObjectOld) || bindsGroups(evt.ObjectNew)
+		},
+		DeleteFunc: func(evt event.DeleteEvent) bool {
+			return bindsGroups(evt.Object)
+		},
+	}
+}
+
+// bindsGroups returns true if the object is a RoleBinding with a group subject
+func bindsGroups(obj interface{}) bool {
+	binding, ok := obj.(*rbacv1.RoleBinding)
+	if !ok {
+		return false
+	}
+	for _, subject := range binding.Subjects {
+		if subject.Kind == rbacv1.GroupKind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package argocd_test
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	"github.com/snorwin/argocd-operator-extension/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("RBACPolicy", func() {
+	var (
+		mockCtrl *gomock.Controller
+		mockHelm *mock_helm.MockClient
+	)
+
+	BeforeEach(func() {
+		testHelmDirectory()
+		Ω(os.Setenv(constants.EnvClusterArgoCDNamespacedNames, "")).ShouldNot(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHelm = mock_helm.NewMockClient(mockCtrl)
+		mockHelm.
+			EXPECT().
+			Upgrade(gomock.Any(), gomock.Any(), gomock.Any(), true).
+			Return(nil).
+			AnyTimes()
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+	It("should_write_policy_to_spec_and_keep_other_lines", func() {
+		argocd := testRBACPolicyArgoCD(constants.RBACPolicyTargetSpec)
+		current := "g, admins, role:admin\n"
+		argocd.Spec.RBAC.Policy = &current
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd),
+			testRoleBinding("myapp", "edit", "developers"), testRoleBinding("othernamespace", "edit", "others"))
+		r.RBACPolicyRoles = policy.ParseRoles("admin,edit", "view")
+
+		actual := testReconcileWith(r, argocd)
+		Ω(actual.Spec.RBAC.Policy).ShouldNot(BeNil())
+		Ω(*actual.Spec.RBAC.Policy).Should(Equal("g, admins, role:admin\n" + policy.BeginMarker + "\n" +
+			"p, developers, applications, create, myapp/*, allow\n" +
+			"p, developers, applications, get, myapp/*, allow\n" +
+			"p, developers, applications, sync, myapp/*, allow\n" +
+			policy.EndMarker + "\n"))
+	})
+	It("should_update_policy_if_bindings_change", func() {
+		argocd := testRBACPolicyArgoCD(constants.RBACPolicyTargetSpec)
+		binding := testRoleBinding("myapp", "edit", "developers")
+
+		r, _ := testReconciler(mockHelm, argocd, testAppProjectNamespace("myapp", argocd), binding)
+		r.RBACPolicyRoles = policy.ParseRoles("admin,edit", "view")
+
+		testReconcileWith(r, argocd)
+
+		binding.RoleRef.Name = "view"
+		Ω(r.Update(context.TODO(), binding)).ShouldNot(HaveOccurred())
+
+		actual := testReconcileWith(r, argocd)
+		Ω(*actual.Spec.RBAC.Policy).Should(Equal(policy.BeginMarker + "\np, developers, applications, get, myapp/*, allow\n" + policy.EndMarker + "\n"))
+
+		Ω(r.Delete(context.TODO(), binding)).ShouldNot(HaveOccurred())
+
+		actual = testReconcileWith(r, argocd)
+		Ω(actual.Spec.RBAC.Policy).ShouldNot(BeNil())
+		Ω(*actual.Spec.RBAC.Policy).Should(BeEmpty())
+	})
+	It("should_write_policy_to_configmap", func() {
+		argocd := testRBACPolicyArgoCD(constants.RBACPolicyTargetConfigMap)
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd-rbac-cm", Namespace: argocd.Namespace},
+			Data:       map[string]string{"policy.csv": "g, admins, role:admin\n"},
+		}
+
+		r, _ := testReconciler(mockHelm, argocd, cm, testRoleBinding("default", "view", "auditors"))
+		r.RBACPolicyRoles = policy.ParseRoles("admin,edit", "view")
+
+		actual := testReconcileWith(r, argocd)
+		Ω(actual.Spec.RBAC.Policy).Should(BeNil())
+
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}, cm)).ShouldNot(HaveOccurred())
+		Ω(cm.Data).Should(HaveKeyWithValue("policy.csv", "g, admins, role:admin\n"+policy.BeginMarker+"\n"+
+			"p, auditors, applications, get, default/*, allow\n"+policy.EndMarker+"\n"))
+	})
+	It("should_remove_generated_block_if_annotation_is_removed", func() {
+		argocd := testRBACPolicyArgoCD(constants.RBACPolicyTargetConfigMap)
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd-rbac-cm", Namespace: argocd.Namespace},
+			Data:       map[string]string{"policy.csv": "g, admins, role:admin\n"},
+		}
+
+		r, _ := testReconciler(mockHelm, argocd, cm, testRoleBinding("default", "view", "auditors"))
+		r.RBACPolicyRoles = policy.ParseRoles("admin,edit", "view")
+		testReconcileWith(r, argocd)
+
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		delete(actual.Annotations, constants.AnnotationRBACPolicy)
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+		testReconcileWith(r, argocd)
+
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name}, cm)).ShouldNot(HaveOccurred())
+		Ω(cm.Data).Should(HaveKeyWithValue("policy.csv", "g, admins, role:admin\n"))
+	})
+	It("should_not_write_policy_if_disabled", func() {
+		argocd := testRBACPolicyArgoCD(constants.RBACPolicyTargetSpec)
+
+		r, _ := testReconciler(mockHelm, argocd, testRoleBinding("default", "edit", "developers"))
+
+		actual := testReconcileWith(r, argocd)
+		Ω(actual.Spec.RBAC.Policy).Should(BeNil())
+	})
+})
+
+func testRBACPolicyArgoCD(target string) *argoprojv1alpha1.ArgoCD {
+	return &argoprojv1alpha1.ArgoCD{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "argocd",
+			Namespace:  "default",
+			Finalizers: []string{constants.FinalizerName},
+			Annotations: map[string]string{
+				constants.AnnotationRBACPolicy: target,
+			},
+		},
+	}
+}
+
+func testRoleBinding(namespace, role, group string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: group + "-" + role, Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: group}},
+	}
+}
@@ -0,0 +1,404 @@
+package argocd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+const (
+	// remoteKeyKubeconfig - key of the kubeconfig in the Secret of a remote cluster
+	remoteKeyKubeconfig = "kubeconfig"
+	// remoteKeyNamespaces - key of the comma separated namespaces on the remote cluster in the Secret of a remote cluster
+	remoteKeyNamespaces = "namespaces"
+	// remoteKeyClusterRole - key of the cluster role which is bound in the namespaces in the Secret of a remote cluster
+	remoteKeyClusterRole = "clusterRole"
+	// remoteKeyServiceAccountNamespace - key of the namespace of the service account in the Secret of a remote cluster
+	remoteKeyServiceAccountNamespace = "serviceAccountNamespace"
+
+	// remoteDefaultClusterRole - cluster role which is bound in the namespaces of a remote cluster by default
+	remoteDefaultClusterRole = "admin"
+	// remoteDefaultServiceAccountNamespace - namespace of the service account on a remote cluster by default
+	remoteDefaultServiceAccountNamespace = "kube-system"
+
+	// labelSecretType - label of the Secrets which ArgoCD reads the clusters from
+	labelSecretType = "argocd.argoproj.io/secret-type"
+	// secretTypeCluster - value of the secret type label of the cluster Secrets
+	secretTypeCluster = "cluster"
+)
+
+// RemoteClientFactory creates the client of a remote cluster
+type RemoteClientFactory func(config *rest.Config) (client.Client, error)
+
+// remoteCluster is a remote cluster declared by a Secret with a kubeconfig
+type remoteCluster struct {
+	name                    string
+	key                     types.NamespacedName
+	resourceVersion         string
+	config                  *rest.Config
+	namespaces              []string
+	clusterRole             string
+	serviceAccountNamespace string
+}
+
+// remoteClient is a client of a remote cluster created from the kubeconfig Secret with the resource version
+type remoteClient struct {
+	resourceVersion string
+	client          client.Client
+}
+
+// clusterConfig is the config of an ArgoCD cluster Secret
+type clusterConfig struct {
+	BearerToken     string          `json:"bearerToken"`
+	TLSClientConfig tlsClientConfig `json:"tlsClientConfig"`
+}
+
+type tlsClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+}
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;create;update;patch;delete
+
+// syncRemoteClusters creates the service account of the ArgoCD instance on the remote clusters of the annotation, binds
+// it in their namespaces and registers the clusters with its token. The objects on the remote clusters which were
+// removed from the annotation are removed and their cluster Secrets deleted.
+func (r *Reconciler) syncRemoteClusters(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) (err error) {
+	if _, ok := obj.Annotations[constants.AnnotationRemoteClusters]; !ok {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "SyncRemoteClusters")
+	defer func() { tracing.End(span, err) }()
+
+	names := remoteClusterNames(obj)
+
+	// an unreachable cluster must neither block the other clusters nor the removal of the dropped ones, its transient
+	// error is returned once all clusters were synced in order that the instance is requeued
+	var failed []string
+	var errs []error
+	for _, name := range names {
+		if err := r.syncRemoteCluster(ctx, obj, name); err != nil {
+			r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonRemoteClusterFailed,
+				"Unable to register remote cluster %s: %v", name, err)
+			failed = append(failed, name)
+			if !IsPermanent(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// remove the objects from the remote clusters which are not declared anymore while their kubeconfig can still be
+	// read, the cluster Secrets are kept until the removal succeeded in order that it is retried
+	list := corev1.SecretList{}
+	if err := r.secrets().List(ctx, &list, client.InNamespace(obj.Namespace), remoteClusterLabels(obj)); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		secret := &list.Items[i]
+		name := strings.TrimPrefix(secret.Name, remoteClusterSecretPrefix(obj))
+		if contains(names, name) {
+			continue
+		}
+
+		if err := r.removeRemoteCluster(ctx, obj, name); err != nil {
+			if !IsPermanent(err) {
+				r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonRemoteClusterFailed,
+					"Unable to remove the service account from remote cluster %s: %v", name, err)
+				errs = append(errs, err)
+				continue
+			}
+			// the kubeconfig is gone or invalid, hence the objects have to be removed by hand
+			sa := remoteServiceAccountName(obj)
+			r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonRemoteClusterFailed,
+				"Unable to remove remote cluster %s, delete the service account %s, its Secret %s-token and the RoleBindings %s in the namespaces %s on %s by hand: %v",
+				name, sa, sa, sa, secret.Data["namespaces"], secret.Data["server"], err)
+		}
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	if len(failed) > 0 {
+		return permanent(fmt.Errorf("invalid remote clusters: %s", strings.Join(failed, ",")))
+	}
+	return nil
+}
+
+// removeRemoteClusters deletes the service account, its token and the role bindings of the ArgoCD instance on its
+// remote clusters, the cluster Secrets are deleted by the garbage collector. Failures are only reported as events in
+// order not to block the deletion of the instance by unreachable clusters.
+func (r *Reconciler) removeRemoteClusters(ctx context.Context, obj *argoprojv1alpha1.ArgoCD) {
+	for _, name := range remoteClusterNames(obj) {
+		if err := r.removeRemoteCluster(ctx, obj, name); err != nil {
+			r.Log.Error(err, "unable to remove remote cluster", "cluster", name)
+			r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonRemoteClusterFailed,
+				"Unable to remove the service account from remote cluster %s: %v", name, err)
+		}
+	}
+}
+
+func (r *Reconciler) removeRemoteCluster(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, name string) error {
+	cluster, err := r.remoteCluster(ctx, obj, name)
+	if err != nil {
+		return err
+	}
+
+	remote, err := r.remoteClient(cluster)
+	if err != nil {
+		return permanent(err)
+	}
+
+	bindings := rbacv1.RoleBindingList{}
+	if err := remote.List(ctx, &bindings, remoteClusterLabels(obj)); err != nil {
+		return err
+	}
+	for i := range bindings.Items {
+		if err := remote.Delete(ctx, &bindings.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	meta := metav1.ObjectMeta{Name: remoteServiceAccountName(obj), Namespace: cluster.serviceAccountNamespace}
+	if err := remote.Delete(ctx, &corev1.ServiceAccount{ObjectMeta: meta}); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	meta.Name += "-token"
+	if err := remote.Delete(ctx, &corev1.Secret{ObjectMeta: meta}); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+
+	r.remoteClients.Delete(cluster.key)
+	return nil
+}
+
+// syncRemoteCluster creates the service account, its token and the role bindings on the remote cluster and writes the
+// cluster Secret of ArgoCD
+func (r *Reconciler) syncRemoteCluster(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, name string) error {
+	cluster, err := r.remoteCluster(ctx, obj, name)
+	if err != nil {
+		return err
+	}
+
+	remote, err := r.remoteClient(cluster)
+	if err != nil {
+		return permanent(err)
+	}
+
+	token, err := r.syncRemoteServiceAccount(ctx, obj, remote, cluster)
+	if err != nil {
+		return err
+	}
+
+	return r.syncClusterSecret(ctx, obj, cluster, token)
+}
+
+// remoteCluster loads the remote cluster from its Secret in the namespace of the ArgoCD instance, changes of the Secret
+// are applied with the next reconciliation of the instance
+func (r *Reconciler) remoteCluster(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, name string) (*remoteCluster, error) {
+	key := types.NamespacedName{Namespace: obj.Namespace, Name: name}
+	secret := &corev1.Secret{}
+	if err := r.secrets().Get(ctx, key, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, permanent(err)
+		}
+		return nil, err
+	}
+
+	config, err := clientcmd.RESTConfigFromKubeConfig(secret.Data[remoteKeyKubeconfig])
+	if err != nil {
+		return nil, permanent(fmt.Errorf("invalid kubeconfig: %w", err))
+	}
+
+	cluster := &remoteCluster{
+		name:                    name,
+		key:                     key,
+		resourceVersion:         secret.ResourceVersion,
+		config:                  config,
+		clusterRole:             remoteDefaultClusterRole,
+		serviceAccountNamespace: remoteDefaultServiceAccountNamespace,
+	}
+	for _, namespace := range strings.Split(string(secret.Data[remoteKeyNamespaces]), ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			cluster.namespaces = add(cluster.namespaces, namespace)
+		}
+	}
+	if len(cluster.namespaces) == 0 {
+		return nil, permanent(fmt.Errorf("no namespaces specified in the key '%s'", remoteKeyNamespaces))
+	}
+	if value := string(secret.Data[remoteKeyClusterRole]); value != "" {
+		cluster.clusterRole = value
+	}
+	if value := string(secret.Data[remoteKeyServiceAccountNamespace]); value != "" {
+		cluster.serviceAccountNamespace = value
+	}
+	return cluster, nil
+}
+
+// remoteClient returns the client of the remote cluster, the clients are reused until the kubeconfig Secret changes
+// since creating them discovers the API of the remote cluster
+func (r *Reconciler) remoteClient(cluster *remoteCluster) (client.Client, error) {
+	if cached, ok := r.remoteClients.Load(cluster.key); ok && cached.(remoteClient).resourceVersion == cluster.resourceVersion {
+		return cached.(remoteClient).client, nil
+	}
+
+	remote, err := r.RemoteClientFactory(cluster.config)
+	if err != nil {
+		return nil, err
+	}
+	r.remoteClients.Store(cluster.key, remoteClient{resourceVersion: cluster.resourceVersion, client: remote})
+	return remote, nil
+}
+
+// secrets returns a client which reads the Secrets directly from the API server, the cache of the manager would watch
+// all Secrets of the cluster
+func (r *Reconciler) secrets() client.Client {
+	if r.APIReader == nil {
+		return r.Client
+	}
+	return &client.DelegatingClient{Reader: r.APIReader, Writer: r.Client, StatusClient: r.Client}
+}
+
+// syncRemoteServiceAccount creates the service account of the ArgoCD instance and its token on the remote cluster and
+// binds the cluster role in the namespaces, the role bindings of other namespaces are deleted. It returns the token.
+func (r *Reconciler) syncRemoteServiceAccount(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, remote client.Client, cluster *remoteCluster) (string, error) {
+	name := remoteServiceAccountName(obj)
+	labels := remoteClusterLabels(obj)
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster.serviceAccountNamespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, remote, sa, func() error {
+		sa.Labels = labels
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	// the token controller populates the token of the Secret, the service accounts have no token Secret since 1.24
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name + "-token", Namespace: cluster.serviceAccountNamespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, remote, secret, func() error {
+		secret.Labels = labels
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[corev1.ServiceAccountNameKey] = name
+		secret.Type = corev1.SecretTypeServiceAccountToken
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	for _, namespace := range cluster.namespaces {
+		binding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		if _, err := controllerutil.CreateOrUpdate(ctx, remote, binding, func() error {
+			binding.Labels = labels
+			binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: cluster.clusterRole}
+			binding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: cluster.serviceAccountNamespace}}
+			return nil
+		}); err != nil {
+			// the role reference of a role binding is immutable
+			if errors.IsInvalid(err) {
+				return "", permanent(err)
+			}
+			return "", err
+		}
+	}
+
+	bindings := rbacv1.RoleBindingList{}
+	if err := remote.List(ctx, &bindings, labels); err != nil {
+		return "", err
+	}
+	for i := range bindings.Items {
+		if binding := &bindings.Items[i]; !contains(cluster.namespaces, binding.Namespace) {
+			if err := remote.Delete(ctx, binding); client.IgnoreNotFound(err) != nil {
+				return "", err
+			}
+		}
+	}
+
+	token := string(secret.Data[corev1.ServiceAccountTokenKey])
+	if token == "" {
+		// retried with backoff until the token controller populated it
+		return "", fmt.Errorf("token of service account %s/%s is not populated yet", cluster.serviceAccountNamespace, name)
+	}
+	return token, nil
+}
+
+// syncClusterSecret writes the cluster Secret of the remote cluster which ArgoCD connects with
+func (r *Reconciler) syncClusterSecret(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, cluster *remoteCluster, token string) error {
+	config, err := json.Marshal(clusterConfig{
+		BearerToken: token,
+		TLSClientConfig: tlsClientConfig{
+			Insecure:   cluster.config.Insecure,
+			ServerName: cluster.config.ServerName,
+			CAData:     cluster.config.CAData,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: remoteClusterSecretPrefix(obj) + cluster.name, Namespace: obj.Namespace}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.secrets(), secret, func() error {
+		secret.Labels = remoteClusterLabels(obj)
+		secret.Labels[labelSecretType] = secretTypeCluster
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{
+			"name":       []byte(cluster.name),
+			"server":     []byte(cluster.config.Host),
+			"namespaces": []byte(strings.Join(cluster.namespaces, ",")),
+			"config":     config,
+		}
+		return controllerutil.SetControllerReference(obj, secret, r.Scheme)
+	})
+	return err
+}
+
+// remoteClusterNames returns the names of the remote cluster Secrets in the annotation
+func remoteClusterNames(obj *argoprojv1alpha1.ArgoCD) []string {
+	var ret []string
+	for _, name := range strings.Split(obj.Annotations[constants.AnnotationRemoteClusters], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret = add(ret, name)
+		}
+	}
+	return ret
+}
+
+// remoteClusterLabels returns the labels of the objects managed for the remote clusters of the ArgoCD instance
+func remoteClusterLabels(obj *argoprojv1alpha1.ArgoCD) client.MatchingLabels {
+	return client.MatchingLabels{
+		constants.LabelManagedBy:       obj.Name,
+		constants.LabelArgoCDNamespace: obj.Namespace,
+	}
+}
+
+// remoteClusterSecretPrefix returns the prefix of the cluster Secrets of the ArgoCD instance
+func remoteClusterSecretPrefix(obj *argoprojv1alpha1.ArgoCD) string {
+	return obj.Name + "-cluster-"
+}
+
+// remoteServiceAccountName returns the name of the service account of the ArgoCD instance on the remote clusters, the
+// namespace is part of it because several ArgoCD instances can share a remote cluster
+func remoteServiceAccountName(obj *argoprojv1alpha1.ArgoCD) string {
+	return fmt.Sprintf("argocd-%s-%s", obj.Namespace, obj.Name)
+}
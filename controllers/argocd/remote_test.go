@@ -0,0 +1,304 @@
+package argocd_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	controller "github.com/snorwin/argocd-operator-extension/controllers/argocd"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+    certificate-authority-data: dGVzdC1jYQ==
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+current-context: prod
+users:
+- name: admin
+  user:
+    token: admin-token
+`
+
+// The remote clusters are fake clients instead of envtest API servers: the envtest binaries (kube-apiserver and etcd)
+// are not part of the test environment, and without a token controller the token of the service account is populated
+// by testPopulateToken either way.
+var _ = Describe("RemoteClusters", func() {
+	var (
+		mockCtrl *gomock.Controller
+		mockHelm *mock_helm.MockClient
+		remote   client.Client
+	)
+
+	BeforeEach(func() {
+		testHelmDirectory()
+		Ω(os.Setenv(constants.EnvClusterArgoCDNamespacedNames, "")).ShouldNot(HaveOccurred())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockHelm = mock_helm.NewMockClient(mockCtrl)
+		mockHelm.
+			EXPECT().
+			Upgrade(gomock.Any(), gomock.Any(), gomock.Any(), true).
+			Return(nil).
+			AnyTimes()
+
+		remote = fake.NewFakeClientWithScheme(scheme.Scheme)
+	})
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+	It("should_register_remote_cluster_with_token", func() {
+		argocd := testRemoteArgoCD("prod")
+
+		r, _ := testRemoteReconciler(mockHelm, remote, argocd, testRemoteSecret("prod", "app-a,app-b"))
+
+		// the token is not populated by the fake client
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		Ω(err).Should(HaveOccurred())
+
+		sa := &corev1.ServiceAccount{}
+		Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: "kube-system", Name: "argocd-default-argocd"}, sa)).ShouldNot(HaveOccurred())
+		for _, namespace := range []string{"app-a", "app-b"} {
+			binding := &rbacv1.RoleBinding{}
+			Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "argocd-default-argocd"}, binding)).ShouldNot(HaveOccurred())
+			Ω(binding.RoleRef.Name).Should(Equal("admin"))
+			Ω(binding.Subjects).Should(ConsistOf(rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: sa.Name, Namespace: sa.Namespace}))
+		}
+
+		testPopulateToken(remote, "argocd-default-argocd-token")
+		testReconcileWith(r, argocd)
+
+		secret := &corev1.Secret{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: argocd.Namespace, Name: "argocd-cluster-prod"}, secret)).ShouldNot(HaveOccurred())
+		Ω(secret.Labels).Should(HaveKeyWithValue("argocd.argoproj.io/secret-type", "cluster"))
+		Ω(secret.Data).Should(HaveKeyWithValue("name", []byte("prod")))
+		Ω(secret.Data).Should(HaveKeyWithValue("server", []byte("https://prod.example.com:6443")))
+		Ω(secret.Data).Should(HaveKeyWithValue("namespaces", []byte("app-a,app-b")))
+
+		config := map[string]interface{}{}
+		Ω(json.Unmarshal(secret.Data["config"], &config)).ShouldNot(HaveOccurred())
+		Ω(config).Should(HaveKeyWithValue("bearerToken", "remote-token"))
+		Ω(config["tlsClientConfig"]).Should(HaveKeyWithValue("caData", base64.StdEncoding.EncodeToString([]byte("test-ca"))))
+	})
+	It("should_sync_remote_namespaces_and_clusters", func() {
+		argocd := testRemoteArgoCD("prod")
+		kubeconfig := testRemoteSecret("prod", "app-a,app-b")
+
+		r, _ := testRemoteReconciler(mockHelm, remote, argocd, kubeconfig)
+		_, _ = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		testPopulateToken(remote, "argocd-default-argocd-token")
+		testReconcileWith(r, argocd)
+
+		// remove a namespace
+		kubeconfig.Data["namespaces"] = []byte("app-a")
+		Ω(r.Update(context.TODO(), kubeconfig)).ShouldNot(HaveOccurred())
+		testReconcileWith(r, argocd)
+
+		bindings := &rbacv1.RoleBindingList{}
+		Ω(remote.List(context.TODO(), bindings)).ShouldNot(HaveOccurred())
+		Ω(bindings.Items).Should(HaveLen(1))
+		Ω(bindings.Items[0].Namespace).Should(Equal("app-a"))
+
+		// remove the cluster
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		actual.Annotations[constants.AnnotationRemoteClusters] = ""
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+		testReconcileWith(r, argocd)
+
+		secret := &corev1.Secret{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: argocd.Namespace, Name: "argocd-cluster-prod"}, secret)).Should(HaveOccurred())
+
+		// the service account, its token and the role bindings are removed from the remote cluster
+		Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: "kube-system", Name: "argocd-default-argocd"}, &corev1.ServiceAccount{})).Should(HaveOccurred())
+		Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: "kube-system", Name: "argocd-default-argocd-token"}, &corev1.Secret{})).Should(HaveOccurred())
+		Ω(remote.List(context.TODO(), bindings)).ShouldNot(HaveOccurred())
+		Ω(bindings.Items).Should(BeEmpty())
+	})
+	It("should_remove_dropped_cluster_if_another_cluster_is_unreachable", func() {
+		argocd := testRemoteArgoCD("prod")
+
+		r, _ := testRemoteReconciler(mockHelm, remote, argocd, testRemoteSecret("prod", "app-a"))
+		_, _ = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		testPopulateToken(remote, "argocd-default-argocd-token")
+		testReconcileWith(r, argocd)
+
+		// replace the cluster by a cluster whose service account token is never populated
+		stage := testRemoteSecret("stage", "app-a")
+		stage.Data["kubeconfig"] = []byte(strings.ReplaceAll(testKubeconfig, "prod.example.com", "stage.example.com"))
+		Ω(r.Create(context.TODO(), stage)).ShouldNot(HaveOccurred())
+		unreachable := fake.NewFakeClientWithScheme(scheme.Scheme)
+		r.RemoteClientFactory = func(config *rest.Config) (client.Client, error) {
+			if config.Host == "https://stage.example.com:6443" {
+				return unreachable, nil
+			}
+			return remote, nil
+		}
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		actual.Annotations[constants.AnnotationRemoteClusters] = "stage"
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		Ω(err).Should(HaveOccurred())
+
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: argocd.Namespace, Name: "argocd-cluster-prod"}, &corev1.Secret{})).Should(HaveOccurred())
+		Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: "kube-system", Name: "argocd-default-argocd"}, &corev1.ServiceAccount{})).Should(HaveOccurred())
+	})
+	It("should_report_leftover_objects_if_kubeconfig_of_removed_cluster_is_gone", func() {
+		argocd := testRemoteArgoCD("prod")
+		kubeconfig := testRemoteSecret("prod", "app-a")
+
+		r, recorder := testRemoteReconciler(mockHelm, remote, argocd, kubeconfig)
+		_, _ = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		testPopulateToken(remote, "argocd-default-argocd-token")
+		testReconcileWith(r, argocd)
+
+		// remove the cluster and its kubeconfig
+		Ω(r.Delete(context.TODO(), kubeconfig)).ShouldNot(HaveOccurred())
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		actual.Annotations[constants.AnnotationRemoteClusters] = ""
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+		testReconcileWith(r, argocd)
+
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: argocd.Namespace, Name: "argocd-cluster-prod"}, &corev1.Secret{})).Should(HaveOccurred())
+		Ω(recorder.Events).Should(Receive(And(
+			ContainSubstring(constants.EventReasonRemoteClusterFailed),
+			ContainSubstring("argocd-default-argocd-token"),
+			ContainSubstring("app-a"),
+			ContainSubstring("https://prod.example.com:6443"),
+		)))
+	})
+	It("should_reuse_remote_client_until_kubeconfig_changes", func() {
+		argocd := testRemoteArgoCD("prod")
+		kubeconfig := testRemoteSecret("prod", "app-a")
+
+		r, _ := testRemoteReconciler(mockHelm, remote, argocd, kubeconfig)
+		created := 0
+		r.RemoteClientFactory = func(config *rest.Config) (client.Client, error) {
+			created++
+			return remote, nil
+		}
+
+		_, _ = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		testPopulateToken(remote, "argocd-default-argocd-token")
+		testReconcileWith(r, argocd)
+		Ω(created).Should(Equal(1))
+
+		Ω(r.Get(context.TODO(), types.NamespacedName{Namespace: kubeconfig.Namespace, Name: kubeconfig.Name}, kubeconfig)).ShouldNot(HaveOccurred())
+		kubeconfig.Data["namespaces"] = []byte("app-b")
+		Ω(r.Update(context.TODO(), kubeconfig)).ShouldNot(HaveOccurred())
+		testReconcileWith(r, argocd)
+		Ω(created).Should(Equal(2))
+	})
+	It("should_report_invalid_remote_cluster", func() {
+		argocd := testRemoteArgoCD("prod")
+
+		r, recorder := testRemoteReconciler(mockHelm, remote, argocd)
+
+		// permanent errors are not returned but retried after the resync interval
+		testReconcileWith(r, argocd)
+		Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonRemoteClusterFailed)))
+	})
+	It("should_remove_service_account_from_remote_cluster_on_deletion", func() {
+		argocd := testRemoteArgoCD("prod")
+
+		r, _ := testRemoteReconciler(mockHelm, remote, argocd, testRemoteSecret("prod", "app-a"))
+		_, _ = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		testPopulateToken(remote, "argocd-default-argocd-token")
+		testReconcileWith(r, argocd)
+
+		mockHelm.
+			EXPECT().
+			Uninstall(argocd.Name).
+			Return(nil)
+
+		actual := &argoprojv1alpha1.ArgoCD{}
+		Ω(r.Get(context.TODO(), types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}, actual)).ShouldNot(HaveOccurred())
+		now := metav1.NewTime(time.Now())
+		actual.DeletionTimestamp = &now
+		Ω(r.Update(context.TODO(), actual)).ShouldNot(HaveOccurred())
+
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: "kube-system", Name: "argocd-default-argocd"}, &corev1.ServiceAccount{})).Should(HaveOccurred())
+		bindings := &rbacv1.RoleBindingList{}
+		Ω(remote.List(context.TODO(), bindings)).ShouldNot(HaveOccurred())
+		Ω(bindings.Items).Should(BeEmpty())
+	})
+})
+
+func testRemoteReconciler(mockHelm *mock_helm.MockClient, remote client.Client, objects ...runtime.Object) (*controller.Reconciler, *record.FakeRecorder) {
+	r, recorder := testReconciler(mockHelm, objects...)
+	r.RemoteClientFactory = func(config *rest.Config) (client.Client, error) {
+		Ω(config.Host).Should(Equal("https://prod.example.com:6443"))
+		return remote, nil
+	}
+	return r, recorder
+}
+
+func testRemoteArgoCD(clusters string) *argoprojv1alpha1.ArgoCD {
+	return &argoprojv1alpha1.ArgoCD{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "argocd",
+			Namespace:  "default",
+			Finalizers: []string{constants.FinalizerName},
+			Annotations: map[string]string{
+				constants.AnnotationRemoteClusters: clusters,
+			},
+		},
+	}
+}
+
+func testRemoteSecret(name, namespaces string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data: map[string][]byte{
+			"kubeconfig": []byte(testKubeconfig),
+			"namespaces": []byte(namespaces),
+		},
+	}
+}
+
+// testPopulateToken sets the token of the service account token Secret like the token controller
+func testPopulateToken(remote client.Client, name string) {
+	secret := &corev1.Secret{}
+	Ω(remote.Get(context.TODO(), types.NamespacedName{Namespace: "kube-system", Name: name}, secret)).ShouldNot(HaveOccurred())
+	Ω(secret.Type).Should(Equal(corev1.SecretTypeServiceAccountToken))
+	secret.Data = map[string][]byte{corev1.ServiceAccountTokenKey: []byte("remote-token")}
+	Ω(remote.Update(context.TODO(), secret)).ShouldNot(HaveOccurred())
+}
@@ -0,0 +1,112 @@
+package argocd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	"github.com/snorwin/argocd-operator-extension/pkg/mapper"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+// SetupRestoreWithManager registers the restore of the bound namespaces of the given namespaces to the Manager, it runs
+// once on the elected leader after the cache has synced. A failed restore is logged and does not stop the manager since
+// every instance records its bound namespaces on its next reconciliation anyway.
+func (r *Reconciler) SetupRestoreWithManager(mgr ctrl.Manager, namespaces []string) error {
+	return mgr.Add(manager.RunnableFunc(func(_ <-chan struct{}) error {
+		for _, namespace := range namespaces {
+			if err := r.RestoreNamespaces(context.Background(), mgr.GetAPIReader(), namespace); err != nil {
+				r.Log.Error(err, "unable to restore bound namespaces", "namespace", namespace)
+			}
+		}
+		return nil
+	}))
+}
+
+// RestoreNamespaces records the namespaces of the deployed Helm releases in the ArgoCD instances of the namespace which
+// did not record their bound namespaces yet (e.g. they were deployed by a previous version of the extension), in order
+// that a namespace which is unlabelled before the instance is reconciled still enqueues the instance
+func (r *Reconciler) RestoreNamespaces(ctx context.Context, reader client.Reader, namespace string) (err error) {
+	ctx, span := tracing.Start(ctx, "RestoreNamespaces")
+	defer func() { tracing.End(span, err) }()
+
+	// set default factory if it was not set before
+	if r.HelmFactory == nil {
+		r.HelmFactory = helm.NewClientForNamespace
+	}
+
+	chart, err := r.loadChart(ctx)
+	if err != nil {
+		return err
+	}
+
+	lister, err := r.HelmFactory(namespace, helm.WithContext(ctx), helm.WithLogger(r.Log), helm.WithHelmDriver(driver), helm.WithMaxHistory(maxHistory))
+	if err != nil {
+		return err
+	}
+
+	releases, err := lister.List()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, release := range blueprintReleases(chart, releases) {
+		key := types.NamespacedName{Namespace: release.Namespace, Name: release.Name}
+
+		obj := argoprojv1alpha1.ArgoCD{}
+		if err := reader.Get(ctx, key, &obj); err != nil {
+			// orphaned releases are uninstalled by the sweeper
+			if !errors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		namespaces := releaseNamespaces(release)
+		if _, ok := obj.Annotations[constants.AnnotationNamespaces]; ok || len(namespaces) == 0 {
+			continue
+		}
+
+		// the patch fails on a conflict if the instance was reconciled in the meantime, its recorded namespaces are
+		// more recent than the ones of the release
+		patch := client.MergeFromWithOptions(obj.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
+		}
+		obj.Annotations[constants.AnnotationNamespaces] = strings.Join(namespaces, ",")
+		if err := r.Patch(ctx, &obj, patch); err != nil {
+			if !errors.IsConflict(err) && !errors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		r.Log.Info("restore bound namespaces", "argocd", key, "namespaces", mapper.RecordedNamespaces(&obj))
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// releaseNamespaces returns the namespaces of the values which were used to install or upgrade a release
+func releaseNamespaces(release *release.Release) []string {
+	var ret []string
+	if values, ok := release.Config["namespaces"].([]interface{}); ok {
+		for _, value := range values {
+			if namespace, ok := value.(string); ok {
+				ret = append(ret, namespace)
+			}
+		}
+	}
+	return ret
+}
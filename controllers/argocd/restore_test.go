@@ -0,0 +1,98 @@
+package argocd_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/golang/mock/gomock"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/mapper"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Restore", func() {
+	Context("RestoreNamespaces", func() {
+		var (
+			mockCtrl *gomock.Controller
+			mockHelm *mock_helm.MockClient
+		)
+		BeforeEach(func() {
+			testHelmDirectory()
+
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockHelm = mock_helm.NewMockClient(mockCtrl)
+		})
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+		It("should_record_namespaces_of_releases", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd", Namespace: "default", ResourceVersion: "1"}}
+			recorded := &argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "recorded", Namespace: "default",
+				Annotations: map[string]string{constants.AnnotationNamespaces: "default"}}}
+
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{
+					testRestoreRelease(argocd.Name, "default", "myapp1"),
+					testRestoreRelease(recorded.Name, "default", "myapp1"),
+					testRestoreRelease("orphaned", "default", "myapp1"),
+					testRelease("other", "default", "other-chart"),
+				}, nil)
+
+			r, _ := testReconciler(mockHelm, argocd, recorded)
+			Ω(r.RestoreNamespaces(context.TODO(), r, "default")).ShouldNot(HaveOccurred())
+
+			// the previous instance of an unlabelled namespace is found by the mapper
+			argocds, err := mapper.ListRecordingArgoCDs(context.TODO(), r, "myapp1")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(argocds).Should(HaveLen(1))
+			Ω(argocds[0].Name).Should(Equal(argocd.Name))
+			Ω(argocds[0].Annotations).Should(HaveKeyWithValue(constants.AnnotationNamespaces, "default,myapp1"))
+		})
+		It("should_not_overwrite_namespaces_recorded_in_the_meantime", func() {
+			stale := &argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd", Namespace: "default", ResourceVersion: "1"}}
+			reconciled := stale.DeepCopy()
+			reconciled.ResourceVersion = "2"
+			reconciled.Annotations = map[string]string{constants.AnnotationNamespaces: "default"}
+
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRestoreRelease(stale.Name, "default", "myapp1")}, nil)
+
+			r, _ := testReconciler(mockHelm, reconciled)
+			reader, _ := testReconciler(mockHelm, stale)
+			Ω(r.RestoreNamespaces(context.TODO(), reader, "default")).ShouldNot(HaveOccurred())
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: stale.Name, Namespace: stale.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationNamespaces, "default"))
+		})
+		It("should_return_list_errors", func() {
+			mockHelm.
+				EXPECT().
+				List().
+				Return(nil, errors.New("list failed"))
+
+			r, _ := testReconciler(mockHelm)
+			Ω(r.RestoreNamespaces(context.TODO(), r, "")).Should(HaveOccurred())
+		})
+	})
+})
+
+func testRestoreRelease(name, namespace string, namespaces ...string) *release.Release {
+	rls := testRelease(name, namespace, "argocd-rbac-blueprint")
+	rls.Config = map[string]interface{}{"namespaces": []interface{}{namespace}}
+	for _, ns := range namespaces {
+		rls.Config["namespaces"] = append(rls.Config["namespaces"].([]interface{}), ns)
+	}
+	return rls
+}
@@ -0,0 +1,318 @@
+package argocd
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	"github.com/snorwin/jsonpatch"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list
+
+// Rollout updates the ArgoCD image of the instances with the 'Always' update policy in waves: first the
+// canary instances, then batches of a percentage of all instances. A wave starts once all the instances of the
+// previous one are healthy, the rollout halts if an instance does not become healthy within the timeout.
+type Rollout struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+
+	// Reader reads the deployments of the instances bypassing the cache (e.g. the API reader of the manager), the
+	// client is used if nil
+	Reader client.Reader
+
+	// Interval between two steps of the rollout
+	Interval time.Duration
+	// BatchPercent is the percentage of all the instances which are updated per wave after the canary instances
+	BatchPercent int
+	// HealthTimeout is the duration after which an updated instance which is not healthy halts the rollout
+	HealthTimeout time.Duration
+
+	// Catalog provides the rolled out ArgoCD image, the ARGOCD_IMAGE environment variable is used if nil
+	Catalog *catalog.Catalog
+
+	// ImageHistoryLimit is the maximum number of image history entries per instance (default: 10)
+	ImageHistoryLimit int
+
+	// Mirrors rewrites the registry of the rolled out image
+	Mirrors image.Rules
+
+	// Resolver pins the rolled out image to the digest of its tag, the image is not resolved if it is nil
+	Resolver image.Resolver
+
+	// Clock is used to track the progress of the rollout (default: real clock)
+	Clock clock.PassiveClock
+}
+
+// SetupWithManager register the Rollout to the Manager
+func (r *Rollout) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("argocd-operator-extension")
+	}
+	return mgr.Add(r)
+}
+
+// Start implements the manager.Runnable interface
+func (r *Rollout) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := r.Step(context.Background()); err != nil {
+			r.Log.Error(err, "unable to roll out ArgoCD image")
+		}
+	}, r.Interval, stop)
+
+	return nil
+}
+
+// Step checks the health of the instances of the current wave and starts the next wave if all of them are healthy
+func (r *Rollout) Step(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Rollout")
+	defer func() { tracing.End(span, err) }()
+
+	images, ok := targetImages(r.Catalog)
+	value := images[catalog.ComponentArgoCD]
+	if !ok || value == "" {
+		return nil
+	}
+	ref, err := image.Parse(value)
+	if err != nil {
+		return err
+	}
+	ref = r.Mirrors.Rewrite(ref)
+	if r.Resolver != nil && ref.Repository != "" {
+		if ref, err = r.Resolver.Resolve(ctx, ref); err != nil {
+			return err
+		}
+	}
+	target, image, version := ref.String(), ref.Repository, ref.Version()
+
+	list := argoprojv1alpha1.ArgoCDList{}
+	if err := r.List(ctx, &list); err != nil {
+		return err
+	}
+
+	var instances, updated, pending []*argoprojv1alpha1.ArgoCD
+	for i := range list.Items {
+		obj := &list.Items[i]
+		if imagePolicy(obj, catalog.ComponentArgoCD) != constants.ImageVersionUpdatePolicyAlways || !obj.DeletionTimestamp.IsZero() ||
+			obj.Annotations[constants.AnnotationImageUpdatesPaused] == "true" || obj.Annotations[constants.AnnotationPaused] == "true" {
+			continue
+		}
+		instances = append(instances, obj)
+		if (image == "" || obj.Spec.Image == image) && (version == "" || obj.Spec.Version == version) {
+			updated = append(updated, obj)
+		} else {
+			pending = append(pending, obj)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// halt the rollout if an instance failed, it continues once the annotation is removed or the image changes
+	for _, obj := range updated {
+		if obj.Annotations[constants.AnnotationRolloutFailed] == target {
+			r.Log.Info("rollout halted", "image", target, "argocd", types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name})
+			return nil
+		}
+	}
+
+	// wait until the instances of the current wave are healthy
+	inProgress := false
+	for _, obj := range updated {
+		started, ok := obj.Annotations[constants.AnnotationRolloutStarted]
+		if !ok {
+			continue
+		}
+
+		healthy, err := r.healthy(ctx, obj, image, version)
+		if err != nil {
+			return err
+		}
+		if healthy {
+			if err := r.patchAnnotation(ctx, obj, constants.AnnotationRolloutStarted, ""); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if t, err := time.Parse(time.RFC3339, started); err != nil || r.now().Sub(t) > r.HealthTimeout {
+			r.Recorder.Eventf(obj, corev1.EventTypeWarning, constants.EventReasonRolloutFailed,
+				"ArgoCD did not become healthy within %s after the update to %s, the rollout is halted", r.HealthTimeout, target)
+			original := obj.DeepCopy()
+			delete(obj.Annotations, constants.AnnotationRolloutStarted)
+			obj.Annotations[constants.AnnotationRolloutFailed] = target
+			return r.patch(ctx, obj, original)
+		}
+		inProgress = true
+	}
+	if inProgress {
+		return nil
+	}
+
+	for _, obj := range r.nextWave(instances, pending) {
+		windows, err := maintenanceWindows(obj)
+		if err != nil {
+			r.Log.Error(err, "invalid maintenance window", "argocd", types.NamespacedName{Namespace: obj.Namespace, Name: obj.Name})
+			continue
+		}
+		if !windows.Open(r.now()) {
+			continue
+		}
+
+		if err := r.update(ctx, obj, image, version); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(obj, corev1.EventTypeNormal, constants.EventReasonRolloutStarted, "Updated ArgoCD image to %s", target)
+	}
+
+	return nil
+}
+
+// nextWave returns the pending canary instances or, once all canary instances are updated, a batch of the pending
+// instances sorted by namespace and name
+func (r *Rollout) nextWave(instances, pending []*argoprojv1alpha1.ArgoCD) []*argoprojv1alpha1.ArgoCD {
+	sort.Slice(pending, func(i, j int) bool {
+		return types.NamespacedName{Namespace: pending[i].Namespace, Name: pending[i].Name}.String() <
+			types.NamespacedName{Namespace: pending[j].Namespace, Name: pending[j].Name}.String()
+	})
+
+	var canaries []*argoprojv1alpha1.ArgoCD
+	for _, obj := range pending {
+		if obj.Labels[constants.LabelCanary] == "true" {
+			canaries = append(canaries, obj)
+		}
+	}
+	if len(canaries) > 0 {
+		return canaries
+	}
+
+	size := int(math.Ceil(float64(len(instances)*r.BatchPercent) / 100))
+	if size < 1 {
+		size = 1
+	}
+	if size > len(pending) {
+		size = len(pending)
+	}
+	return pending[:size]
+}
+
+// healthy returns true if the ArgoCD server and repo server deployments of the instance run the image and are available
+func (r *Rollout) healthy(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, image, version string) (bool, error) {
+	for _, name := range []string{obj.Name + "-server", obj.Name + "-repo-server"} {
+		deployment := appsv1.Deployment{}
+		if err := r.reader().Get(ctx, types.NamespacedName{Namespace: obj.Namespace, Name: name}, &deployment); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				return false, nil
+			}
+			return false, err
+		}
+
+		if !deploymentAvailable(&deployment) || !deploymentRunsImage(&deployment, image, version) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// update sets the image and version of the instance and records the start of its rollout
+func (r *Rollout) update(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, image, version string) error {
+	original := obj.DeepCopy()
+	if image != "" {
+		obj.Spec.Image = image
+	}
+	if version != "" {
+		obj.Spec.Version = version
+	}
+	if obj.Annotations == nil {
+		obj.Annotations = make(map[string]string)
+	}
+	obj.Annotations[constants.AnnotationRolloutStarted] = r.now().UTC().Format(time.RFC3339)
+	if err := recordImages(obj, original, r.now(), r.ImageHistoryLimit); err != nil {
+		return err
+	}
+
+	return r.patch(ctx, obj, original)
+}
+
+// patchAnnotation sets the annotation of the instance, an empty value removes it
+func (r *Rollout) patchAnnotation(ctx context.Context, obj *argoprojv1alpha1.ArgoCD, key, value string) error {
+	original := obj.DeepCopy()
+	if value == "" {
+		delete(obj.Annotations, key)
+	} else {
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
+		}
+		obj.Annotations[key] = value
+	}
+
+	return r.patch(ctx, obj, original)
+}
+
+func (r *Rollout) patch(ctx context.Context, obj, original *argoprojv1alpha1.ArgoCD) error {
+	patches, err := jsonpatch.CreateJSONPatch(obj, original)
+	if err != nil {
+		return err
+	}
+	if patches.Len() == 0 {
+		return nil
+	}
+	return r.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patches.Raw()))
+}
+
+func (r *Rollout) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+func (r *Rollout) reader() client.Reader {
+	if r.Reader != nil {
+		return r.Reader
+	}
+	return r.Client
+}
+
+// deploymentAvailable returns true if the latest generation of the deployment is rolled out and all replicas are available
+func deploymentAvailable(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.AvailableReplicas == replicas &&
+		deployment.Status.Replicas == replicas
+}
+
+// deploymentRunsImage returns true if a container of the deployment runs the repository and version (tag or digest)
+func deploymentRunsImage(deployment *appsv1.Deployment, repository, version string) bool {
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		ref, err := image.Parse(container.Image)
+		if err != nil {
+			continue
+		}
+		if (repository == "" || ref.Repository == repository) && (version == "" || ref.Version() == version) {
+			return true
+		}
+	}
+	return false
+}
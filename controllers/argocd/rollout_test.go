@@ -0,0 +1,162 @@
+package argocd_test
+
+import (
+	"context"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	logr "github.com/go-logr/logr/testing"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	controller "github.com/snorwin/argocd-operator-extension/controllers/argocd"
+)
+
+var _ = Describe("Rollout", func() {
+	Context("Step", func() {
+		now := time.Date(2021, time.June, 7, 12, 0, 0, 0, time.UTC)
+
+		BeforeEach(func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argocd:v2.0.5")).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			Ω(os.Unsetenv(constants.EnvArgoCDImage)).ShouldNot(HaveOccurred())
+		})
+		It("should_update_canary_instances_first", func() {
+			canary := testRolloutArgoCD("argocd-c", true)
+
+			r, _ := testRollout(now, canary, testRolloutArgoCD("argocd-a", false), testRolloutArgoCD("argocd-b", false))
+			Ω(r.Step(context.TODO())).ShouldNot(HaveOccurred())
+
+			Ω(testRolloutVersions(r)).Should(Equal(map[string]string{"argocd-a": "v2.0.1", "argocd-b": "v2.0.1", "argocd-c": "v2.0.5"}))
+		})
+		It("should_wait_for_unhealthy_instances_within_timeout", func() {
+			canary := testRolloutArgoCD("argocd-c", true)
+			canary.Spec.Version = "v2.0.5"
+			canary.Annotations[constants.AnnotationRolloutStarted] = now.Add(-5 * time.Minute).Format(time.RFC3339)
+
+			r, _ := testRollout(now, canary, testRolloutArgoCD("argocd-a", false), testRolloutArgoCD("argocd-b", false))
+			Ω(r.Step(context.TODO())).ShouldNot(HaveOccurred())
+
+			Ω(testRolloutVersions(r)).Should(Equal(map[string]string{"argocd-a": "v2.0.1", "argocd-b": "v2.0.1", "argocd-c": "v2.0.5"}))
+		})
+		It("should_update_next_batch_if_canary_is_healthy", func() {
+			canary := testRolloutArgoCD("argocd-c", true)
+			canary.Spec.Version = "v2.0.5"
+			canary.Annotations[constants.AnnotationRolloutStarted] = now.Add(-5 * time.Minute).Format(time.RFC3339)
+
+			r, _ := testRollout(now, canary, testRolloutArgoCD("argocd-a", false), testRolloutArgoCD("argocd-b", false),
+				testDeployment(canary.Name+"-server", "argocd:v2.0.5", true), testDeployment(canary.Name+"-repo-server", "argocd:v2.0.5", true))
+			Ω(r.Step(context.TODO())).ShouldNot(HaveOccurred())
+
+			Ω(testRolloutVersions(r)).Should(Equal(map[string]string{"argocd-a": "v2.0.5", "argocd-b": "v2.0.1", "argocd-c": "v2.0.5"}))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: canary.Name, Namespace: canary.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Annotations).ShouldNot(HaveKey(constants.AnnotationRolloutStarted))
+		})
+		It("should_halt_if_instance_does_not_become_healthy", func() {
+			canary := testRolloutArgoCD("argocd-c", true)
+			canary.Spec.Version = "v2.0.5"
+			canary.Annotations[constants.AnnotationRolloutStarted] = now.Add(-20 * time.Minute).Format(time.RFC3339)
+
+			r, recorder := testRollout(now, canary, testRolloutArgoCD("argocd-a", false), testRolloutArgoCD("argocd-b", false),
+				testDeployment(canary.Name+"-server", "argocd:v2.0.5", false), testDeployment(canary.Name+"-repo-server", "argocd:v2.0.5", true))
+			Ω(r.Step(context.TODO())).ShouldNot(HaveOccurred())
+			Ω(recorder.Events).Should(Receive(ContainSubstring(constants.EventReasonRolloutFailed)))
+
+			actual := &argoprojv1alpha1.ArgoCD{}
+			Ω(r.Get(context.TODO(), types.NamespacedName{Name: canary.Name, Namespace: canary.Namespace}, actual)).ShouldNot(HaveOccurred())
+			Ω(actual.Annotations).Should(HaveKeyWithValue(constants.AnnotationRolloutFailed, "argocd:v2.0.5"))
+			Ω(actual.Annotations).ShouldNot(HaveKey(constants.AnnotationRolloutStarted))
+
+			// the rollout remains halted
+			Ω(r.Step(context.TODO())).ShouldNot(HaveOccurred())
+			Ω(testRolloutVersions(r)).Should(Equal(map[string]string{"argocd-a": "v2.0.1", "argocd-b": "v2.0.1", "argocd-c": "v2.0.5"}))
+		})
+	})
+})
+
+func testRollout(now time.Time, objects ...runtime.Object) (*controller.Rollout, *record.FakeRecorder) {
+	s := scheme.Scheme
+	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+	recorder := record.NewFakeRecorder(10)
+	return &controller.Rollout{
+		Client:        client.NewFakeClientWithScheme(s, objects...),
+		Log:           logr.NullLogger{},
+		Recorder:      recorder,
+		BatchPercent:  25,
+		HealthTimeout: 10 * time.Minute,
+		Clock:         clock.NewFakeClock(now),
+	}, recorder
+}
+
+func testRolloutArgoCD(name string, canary bool) *argoprojv1alpha1.ArgoCD {
+	argocd := &argoprojv1alpha1.ArgoCD{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Annotations: map[string]string{
+				constants.AnnotationImageVersionUpdatePolicy: constants.ImageVersionUpdatePolicyAlways,
+			},
+		},
+		Spec: argoprojv1alpha1.ArgoCDSpec{
+			Image:   "argocd",
+			Version: "v2.0.1",
+		},
+	}
+	if canary {
+		argocd.Labels = map[string]string{constants.LabelCanary: "true"}
+	}
+	return argocd
+}
+
+func testRolloutVersions(r *controller.Rollout) map[string]string {
+	list := argoprojv1alpha1.ArgoCDList{}
+	Ω(r.List(context.TODO(), &list)).ShouldNot(HaveOccurred())
+
+	ret := make(map[string]string)
+	for _, argocd := range list.Items {
+		ret[argocd.Name] = argocd.Spec.Version
+	}
+	return ret
+}
+
+func testDeployment(name, image string, available bool) *appsv1.Deployment {
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "argocd", Image: image}},
+				},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:        1,
+			UpdatedReplicas: 1,
+		},
+	}
+	if available {
+		deployment.Status.AvailableReplicas = 1
+	}
+	return deployment
+}
@@ -0,0 +1,143 @@
+package argocd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	"github.com/snorwin/argocd-operator-extension/pkg/metrics"
+	"github.com/snorwin/argocd-operator-extension/pkg/sharding"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+// Sweeper periodically uninstalls the Helm releases of ArgoCD instances which do not exist anymore (e.g. because the
+// finalizer was removed by hand or the extension was not running while the instance was deleted)
+type Sweeper struct {
+	client.Client
+	Log logr.Logger
+
+	// HelmFactory is a factory function to create new Helm clients
+	HelmFactory helm.ClientFactory
+
+	// Namespaces restricts the sweeper to the releases of the given namespaces, all namespaces are swept if it is empty
+	Namespaces []string
+	// Interval between two sweeps
+	Interval time.Duration
+	// DryRun only reports orphaned releases instead of uninstalling them
+	DryRun bool
+
+	// Sharder restricts the sweeper to the releases of the ArgoCD instances assigned to this replica, if set
+	Sharder *sharding.Sharder
+}
+
+// SetupWithManager register the Sweeper to the Manager
+func (s *Sweeper) SetupWithManager(mgr ctrl.Manager) error {
+	// set default factory if it was not set before
+	if s.HelmFactory == nil {
+		s.HelmFactory = helm.NewClientForNamespace
+	}
+	s.HelmFactory = metrics.InstrumentHelmClientFactory(s.HelmFactory)
+
+	return mgr.Add(s)
+}
+
+// Start implements the manager.Runnable interface
+func (s *Sweeper) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := s.Sweep(context.Background()); err != nil {
+			s.Log.Error(err, "unable to sweep orphaned helm releases")
+		}
+	}, s.Interval, stop)
+
+	return nil
+}
+
+// Sweep lists all Helm releases of the RBAC blueprint chart and uninstalls (or reports) the ones without an ArgoCD instance
+func (s *Sweeper) Sweep(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Sweep")
+	defer func() { tracing.End(span, err) }()
+
+	// load helm chart in order to identify the releases created by the extension
+	chart, err := loader.Load(os.Getenv(constants.EnvHelmDirectory))
+	if err != nil {
+		metrics.ChartLoadFailures.Inc()
+		return err
+	}
+
+	namespaces := s.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	var releases []*release.Release
+	for _, namespace := range namespaces {
+		lister, err := s.HelmFactory(namespace, helm.WithContext(ctx), helm.WithLogger(s.Log), helm.WithHelmDriver(driver), helm.WithMaxHistory(maxHistory))
+		if err != nil {
+			return err
+		}
+
+		list, err := lister.List()
+		if err != nil {
+			return err
+		}
+		releases = append(releases, list...)
+	}
+
+	// a release which cannot be uninstalled (e.g. because of a failing hook) must not block the other releases
+	var errs []error
+	for _, release := range blueprintReleases(chart, releases) {
+		key := types.NamespacedName{Namespace: release.Namespace, Name: release.Name}
+		if s.Sharder != nil && !s.Sharder.Owns(key) {
+			continue
+		}
+		if err := s.Get(ctx, key, &argoprojv1alpha1.ArgoCD{}); err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			errs = append(errs, err)
+			continue
+		}
+
+		logger := s.Log.WithValues("argocd", key)
+		if s.DryRun {
+			logger.Info("found orphaned helm release")
+			continue
+		}
+
+		logger.Info("uninstall orphaned helm release")
+		helm, err := s.HelmFactory(release.Namespace, helm.WithContext(ctx), helm.WithLogger(logger), helm.WithHelmDriver(driver), helm.WithMaxHistory(maxHistory))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := helm.Uninstall(release.Name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// blueprintReleases filters the releases which are installed from the given (RBAC blueprint) helm chart
+func blueprintReleases(chart *chart.Chart, releases []*release.Release) []*release.Release {
+	var ret []*release.Release
+	for _, release := range releases {
+		if release.Chart != nil && release.Chart.Metadata != nil && chart.Metadata != nil && release.Chart.Metadata.Name == chart.Metadata.Name {
+			ret = append(ret, release)
+		}
+	}
+	return ret
+}
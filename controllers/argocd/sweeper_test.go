@@ -0,0 +1,191 @@
+package argocd_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/go-logr/logr"
+	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/golang/mock/gomock"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	controller "github.com/snorwin/argocd-operator-extension/controllers/argocd"
+)
+
+var _ = Describe("Sweeper", func() {
+	Context("Sweep", func() {
+		var (
+			mockCtrl *gomock.Controller
+			mockHelm *mock_helm.MockClient
+		)
+		BeforeEach(func() {
+			wd, err := os.Getwd()
+			Ω(err).ShouldNot(HaveOccurred())
+			d := filepath.Join(wd, "/../../helm/charts/argocd-operator-extension/resources")
+			Ω(os.Setenv(constants.EnvHelmDirectory, d)).ShouldNot(HaveOccurred())
+
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockHelm = mock_helm.NewMockClient(mockCtrl)
+		})
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+		It("should_uninstall_orphaned_release", func() {
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRelease("argocd", "default", "argocd-rbac-blueprint")}, nil)
+			mockHelm.
+				EXPECT().
+				Uninstall("argocd").
+				Return(nil)
+
+			Ω(testSweeper(mockHelm, false).Sweep(context.TODO())).ShouldNot(HaveOccurred())
+		})
+		It("should_not_uninstall_release_of_existing_argocd", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+				},
+			}
+
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRelease(argocd.Name, argocd.Namespace, "argocd-rbac-blueprint")}, nil)
+
+			Ω(testSweeper(mockHelm, false, argocd).Sweep(context.TODO())).ShouldNot(HaveOccurred())
+		})
+		It("should_ignore_releases_of_other_charts", func() {
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRelease("other", "default", "other-chart")}, nil)
+
+			Ω(testSweeper(mockHelm, false).Sweep(context.TODO())).ShouldNot(HaveOccurred())
+		})
+		It("should_sweep_each_watched_namespace", func() {
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRelease("argocd", "argocd-a", "argocd-rbac-blueprint")}, nil)
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRelease("argocd", "argocd-b", "argocd-rbac-blueprint")}, nil)
+			mockHelm.
+				EXPECT().
+				Uninstall("argocd").
+				Return(nil).
+				Times(2)
+
+			sweeper := testSweeper(mockHelm, false)
+			sweeper.Namespaces = []string{"argocd-a", "argocd-b"}
+			Ω(sweeper.Sweep(context.TODO())).ShouldNot(HaveOccurred())
+		})
+		It("should_uninstall_remaining_releases_if_an_uninstall_fails", func() {
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{
+					testRelease("stuck", "default", "argocd-rbac-blueprint"),
+					testRelease("argocd", "default", "argocd-rbac-blueprint"),
+				}, nil)
+			mockHelm.
+				EXPECT().
+				Uninstall("stuck").
+				Return(errors.New("pre-delete hook failed"))
+			mockHelm.
+				EXPECT().
+				Uninstall("argocd").
+				Return(nil)
+
+			err := testSweeper(mockHelm, false).Sweep(context.TODO())
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("pre-delete hook failed"))
+		})
+		It("should_only_report_orphaned_release_in_dry_run", func() {
+			mockHelm.
+				EXPECT().
+				List().
+				Return([]*release.Release{testRelease("argocd", "default", "argocd-rbac-blueprint")}, nil)
+			mockHelm.
+				EXPECT().
+				Uninstall(gomock.Any()).
+				Times(0)
+
+			log := &testLog{}
+			sweeper := testSweeper(mockHelm, true)
+			sweeper.Log = log
+			Ω(sweeper.Sweep(context.TODO())).ShouldNot(HaveOccurred())
+			Ω(log.messages).Should(ConsistOf("found orphaned helm release argocd=default/argocd"))
+		})
+	})
+})
+
+func testSweeper(mockHelm *mock_helm.MockClient, dryRun bool, objects ...runtime.Object) *controller.Sweeper {
+	s := scheme.Scheme
+	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+	return &controller.Sweeper{
+		Client: client.NewFakeClientWithScheme(s, objects...),
+		Log:    logrtesting.NullLogger{},
+		HelmFactory: func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
+			return mockHelm, nil
+		},
+		DryRun: dryRun,
+	}
+}
+
+func testRelease(name, namespace, chartName string) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart: &chart.Chart{
+			Metadata: &chart.Metadata{Name: chartName},
+		},
+	}
+}
+
+// testLog records the info messages of a logger with their values
+type testLog struct {
+	logrtesting.NullLogger
+	messages []string
+}
+
+func (l *testLog) Info(msg string, keysAndValues ...interface{}) {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		msg += fmt.Sprintf(" %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	l.messages = append(l.messages, msg)
+}
+
+func (l *testLog) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return &testLogValues{testLog: l, values: keysAndValues}
+}
+
+// testLogValues adds values to the messages recorded by a testLog
+type testLogValues struct {
+	*testLog
+	values []interface{}
+}
+
+func (l *testLogValues) Info(msg string, keysAndValues ...interface{}) {
+	l.testLog.Info(msg, append(append([]interface{}{}, l.values...), keysAndValues...)...)
+}
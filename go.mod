@@ -4,19 +4,29 @@ go 1.16
 
 require (
 	github.com/Azure/go-autorest/autorest/adal v0.9.10 // indirect
+	github.com/Masterminds/semver/v3 v3.1.1
 	github.com/argoproj-labs/argocd-operator v0.0.15
+	github.com/docker/distribution v2.7.1+incompatible
 	github.com/go-logr/logr v0.3.0
 	github.com/golang/mock v1.6.0
 	github.com/onsi/ginkgo v1.16.4
 	github.com/onsi/gomega v1.10.5
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/snorwin/jsonpatch v1.4.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	go.uber.org/zap v1.18.1
+	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
 	helm.sh/helm/v3 v3.5.4
 	k8s.io/api v0.20.4
 	k8s.io/apimachinery v0.20.4
 	k8s.io/client-go v12.0.0+incompatible
 	k8s.io/klog/v2 v2.5.0
 	sigs.k8s.io/controller-runtime v0.6.4
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
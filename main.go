@@ -17,23 +17,41 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"go.uber.org/zap/zapcore"
 	"k8s.io/klog/v2"
+	"net"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	zap2 "go.uber.org/zap"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/snorwin/argocd-operator-extension/controllers/argocd"
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+	"github.com/snorwin/argocd-operator-extension/pkg/multicache"
+	"github.com/snorwin/argocd-operator-extension/pkg/policy"
+	"github.com/snorwin/argocd-operator-extension/pkg/sharding"
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
 	// +kubebuilder:scaffold:imports
 
 	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
@@ -56,11 +74,113 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var migrateHelmDriver string
+	var migrateDryRun bool
+	var sweepInterval time.Duration
+	var sweepDryRun bool
+	var tracingEndpoint string
+	var tracingInsecure bool
+	var namespaceEventWindow time.Duration
+	var maxConcurrentReconciles int
+	var rateLimitBaseDelay time.Duration
+	var rateLimitMaxDelay time.Duration
+	var rateLimitQPS float64
+	var rateLimitBurst int
+	var resyncInterval time.Duration
+	var shard bool
+	var shardIdentity string
+	var shardLeaseNamespace string
+	var shardLeaseDuration time.Duration
+	var shardRenewInterval time.Duration
+	var rollout bool
+	var rolloutInterval time.Duration
+	var rolloutBatchPercent int
+	var rolloutHealthTimeout time.Duration
+	var resolveImageDigests bool
+	var imageDigestCacheTTL time.Duration
+	var imageResolveTimeout time.Duration
+	var plainHTTPRegistries string
+	var enforceRegistryMirrors bool
+	var imageHistoryLimit int
+	var rbacPolicy bool
+	var rbacPolicyEditRoles string
+	var rbacPolicyViewRoles string
+	var networkPolicyExternalCIDR string
+	var networkPolicyClusterCIDRs string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&migrateHelmDriver, "migrate-helm-driver", "",
+		"Migrate the Helm releases of all ArgoCD instances from the given storage driver ('secret' or 'configmap') "+
+			"to the configured storage driver (HELM_DRIVER) before starting the manager.")
+	flag.BoolVar(&migrateDryRun, "migrate-dry-run", false,
+		"Only report the Helm releases which would be migrated and exit without starting the manager.")
+	flag.DurationVar(&sweepInterval, "orphan-sweep-interval", time.Hour,
+		"The interval in which Helm releases of deleted ArgoCD instances are uninstalled. Use 0 to disable the sweeper.")
+	flag.BoolVar(&sweepDryRun, "orphan-sweep-dry-run", false,
+		"Only report Helm releases of deleted ArgoCD instances instead of uninstalling them.")
+	flag.StringVar(&tracingEndpoint, "tracing-otlp-endpoint", "",
+		"The OTLP/HTTP endpoint (host:port) the trace spans are exported to. Tracing is disabled if it is empty.")
+	flag.BoolVar(&tracingInsecure, "tracing-otlp-insecure", false,
+		"Disable TLS for the connection to the OTLP/HTTP endpoint.")
+	flag.DurationVar(&namespaceEventWindow, "namespace-event-window", 2*time.Second,
+		"The time window in which namespace events are coalesced into a single reconciliation per ArgoCD instance. "+
+			"Use 0 to reconcile on every namespace event.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The maximum number of ArgoCD instances which are reconciled concurrently.")
+	flag.DurationVar(&rateLimitBaseDelay, "rate-limit-base-delay", 5*time.Millisecond,
+		"The initial delay before a failed reconciliation of an ArgoCD instance is retried, doubled on every failure.")
+	flag.DurationVar(&rateLimitMaxDelay, "rate-limit-max-delay", 1000*time.Second,
+		"The maximum delay before a failed reconciliation of an ArgoCD instance is retried.")
+	flag.Float64Var(&rateLimitQPS, "rate-limit-qps", 10,
+		"The overall number of reconciliations per second which are requeued.")
+	flag.IntVar(&rateLimitBurst, "rate-limit-burst", 100,
+		"The overall burst of reconciliations which are requeued.")
+	flag.DurationVar(&resyncInterval, "resync-interval", time.Hour,
+		"The interval in which all ArgoCD instances are reconciled periodically and permanent errors are retried. "+
+			"Use 0 to disable the periodic reconciliation.")
+	flag.BoolVar(&shard, "shard", false,
+		"Distribute the ArgoCD instances across all replicas by consistent hashing instead of leader election.")
+	flag.StringVar(&shardIdentity, "shard-identity", os.Getenv(constants.EnvPodName),
+		"The unique identity of the replica for the sharding (default: POD_NAME or the hostname).")
+	flag.StringVar(&shardLeaseNamespace, "shard-lease-namespace", os.Getenv(constants.EnvPodNamespace),
+		"The namespace of the Lease objects of the sharding (default: POD_NAMESPACE).")
+	flag.DurationVar(&shardLeaseDuration, "shard-lease-duration", 15*time.Second,
+		"The duration after which a replica which stopped renewing its Lease leaves the sharding.")
+	flag.DurationVar(&shardRenewInterval, "shard-renew-interval", 5*time.Second,
+		"The interval in which the Lease of the replica is renewed.")
+	flag.BoolVar(&rollout, "rollout", false,
+		"Roll out ARGOCD_IMAGE to the ArgoCD instances with the 'Always' update policy in waves instead of all at once.")
+	flag.DurationVar(&rolloutInterval, "rollout-interval", 30*time.Second,
+		"The interval in which the health of the current wave is checked and the next wave is started.")
+	flag.IntVar(&rolloutBatchPercent, "rollout-batch-percent", 25,
+		"The percentage of all ArgoCD instances which are updated per wave after the canary instances.")
+	flag.DurationVar(&rolloutHealthTimeout, "rollout-health-timeout", 10*time.Minute,
+		"The duration after which an updated ArgoCD instance which is not healthy halts the rollout.")
+	flag.BoolVar(&resolveImageDigests, "resolve-image-digests", false,
+		"Pin the image updates to the digest of their tag and verify that they exist in the registry before the ArgoCD instances are updated.")
+	flag.DurationVar(&imageDigestCacheTTL, "image-digest-cache-ttl", 5*time.Minute,
+		"The duration for which the digest of a tag is cached.")
+	flag.DurationVar(&imageResolveTimeout, "image-resolve-timeout", 30*time.Second,
+		"The timeout of the requests to the registries which resolve the image digests.")
+	flag.StringVar(&plainHTTPRegistries, "plain-http-registries", "",
+		"Comma separated list of registries (<host>[:<port>]) which are accessed with HTTP instead of HTTPS to resolve the image digests.")
+	flag.BoolVar(&enforceRegistryMirrors, "enforce-registry-mirrors", false,
+		"Rewrite the images of all ArgoCD components to the REGISTRY_MIRRORS, including the ones set by hand.")
+	flag.IntVar(&imageHistoryLimit, "image-history-limit", 10,
+		"The maximum number of previous images which are recorded per ArgoCD instance in order to revert them.")
+	flag.BoolVar(&rbacPolicy, "rbac-policy", false,
+		"Watch the RoleBindings and generate the Argo CD RBAC policy of the ArgoCD instances with the rbac-policy annotation.")
+	flag.StringVar(&rbacPolicyEditRoles, "rbac-policy-edit-roles", "admin,edit",
+		"Comma separated list of the roles and cluster roles which grant the bound groups get, create and sync access to the applications.")
+	flag.StringVar(&rbacPolicyViewRoles, "rbac-policy-view-roles", "view",
+		"Comma separated list of the roles and cluster roles which grant the bound groups get access to the applications.")
+	flag.StringVar(&networkPolicyExternalCIDR, "network-policy-external-cidr", "0.0.0.0/0",
+		"The CIDR outside of the cluster (e.g. Kubernetes API server, Git and Helm repositories) which Argo CD can reach with network policies.")
+	flag.StringVar(&networkPolicyClusterCIDRs, "network-policy-cluster-cidrs", "",
+		"Comma separated list of the pod and service CIDRs which are excluded from the external CIDR. The egress of Argo CD is not restricted until they are set.")
 
 	// Use json encoder with iso timestamps
 	encCfg := zap2.NewProductionEncoderConfig()
@@ -79,29 +199,229 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	ns := os.Getenv("WATCH_NAMESPACE")
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Namespace:              ns,
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingEndpoint, tracingInsecure)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "unable to shut down tracing")
+		}
+	}()
+
+	if shard && enableLeaderElection {
+		setupLog.Error(fmt.Errorf("--shard and --leader-elect are mutually exclusive"), "invalid flags")
+		os.Exit(1)
+	}
+	if shard && rollout {
+		setupLog.Error(fmt.Errorf("--shard and --rollout are mutually exclusive"), "invalid flags")
+		os.Exit(1)
+	}
+	if shard && shardIdentity == "" {
+		if shardIdentity, err = os.Hostname(); err != nil {
+			setupLog.Error(err, "unable to determine shard identity")
+			os.Exit(1)
+		}
+	}
+
+	config := ctrl.GetConfigOrDie()
+
+	// resolve the watched namespaces with a direct client, the cache of the manager depends on them
+	reader, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	namespaces, err := multicache.Namespaces(context.Background(), reader, os.Getenv(constants.EnvWatchNamespace), os.Getenv(constants.EnvWatchNamespaceSelector))
+	if err != nil {
+		setupLog.Error(err, "unable to resolve watched namespaces")
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "861ee80c.snorwin.io",
-	})
+	}
+	// the RoleBindings of the bound namespaces are watched outside of the watched namespaces as well
+	var clusterWide []schema.GroupKind
+	if rbacPolicy {
+		clusterWide = append(clusterWide, rbacv1.SchemeGroupVersion.WithKind("RoleBinding").GroupKind())
+	}
+	switch {
+	case len(namespaces) == 0:
+		setupLog.Info("watching all namespaces")
+	case len(namespaces) == 1 && len(clusterWide) == 0:
+		setupLog.Info("watching namespace", "namespace", namespaces[0])
+		options.Namespace = namespaces[0]
+	default:
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+		options.NewCache = multicache.Builder(namespaces, clusterWide...)
+	}
+
+	mgr, err := ctrl.NewManager(config, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err := (&argocd.Reconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ArgoCD"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	mirrors, err := image.ParseRules(os.Getenv(constants.EnvRegistryMirrors))
+	if err != nil {
+		setupLog.Error(err, "invalid registry mirrors")
+		os.Exit(1)
+	}
+
+	reconciler := &argocd.Reconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Log:       ctrl.Log.WithName("controllers").WithName("ArgoCD"),
+		Scheme:    mgr.GetScheme(),
+
+		NamespaceEventWindow:    namespaceEventWindow,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RateLimiter:             argocd.NewRateLimiter(rateLimitBaseDelay, rateLimitMaxDelay, rateLimitQPS, rateLimitBurst),
+		ResyncInterval:          resyncInterval,
+		StagedRollout:           rollout,
+		Mirrors:                 mirrors,
+		EnforceMirrors:          enforceRegistryMirrors,
+		ImageHistoryLimit:       imageHistoryLimit,
+	}
+	if rbacPolicy {
+		reconciler.RBACPolicyRoles = policy.ParseRoles(rbacPolicyEditRoles, rbacPolicyViewRoles)
+	}
+	if _, _, err := net.ParseCIDR(networkPolicyExternalCIDR); err != nil {
+		setupLog.Error(err, "invalid network policy external CIDR")
+		os.Exit(1)
+	}
+	reconciler.NetworkPolicyExternalCIDR = networkPolicyExternalCIDR
+	for _, cidr := range strings.Split(networkPolicyClusterCIDRs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				setupLog.Error(err, "invalid network policy cluster CIDR")
+				os.Exit(1)
+			}
+			reconciler.NetworkPolicyClusterCIDRs = append(reconciler.NetworkPolicyClusterCIDRs, cidr)
+		}
+	}
+	var resolver image.Resolver
+	if resolveImageDigests {
+		var registries []string
+		for _, registry := range strings.Split(plainHTTPRegistries, ",") {
+			if registry = strings.TrimSpace(registry); registry != "" {
+				registries = append(registries, registry)
+			}
+		}
+		resolver = &image.RegistryResolver{
+			Client:    &http.Client{Timeout: imageResolveTimeout},
+			PlainHTTP: registries,
+			TTL:       imageDigestCacheTTL,
+		}
+		reconciler.Resolver = resolver
+	}
+	var imageCatalog *catalog.Catalog
+	if value := os.Getenv(constants.EnvImageCatalog); value != "" {
+		split := strings.SplitN(value, "/", 2)
+		if len(split) != 2 {
+			setupLog.Error(fmt.Errorf("invalid NamespacedName '%s', expected <namespace>/<name>", value), "invalid image catalog")
+			os.Exit(1)
+		}
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to create clientset")
+			os.Exit(1)
+		}
+		imageCatalog = &catalog.Catalog{
+			Client:        mgr.GetClient(),
+			Log:           ctrl.Log.WithName("catalog"),
+			ListerWatcher: catalog.NewListWatch(clientset, types.NamespacedName{Namespace: split[0], Name: split[1]}),
+		}
+		if err := mgr.Add(imageCatalog); err != nil {
+			setupLog.Error(err, "unable to create image catalog")
+			os.Exit(1)
+		}
+		reconciler.Catalog = imageCatalog
+	}
+	var sharder *sharding.Sharder
+	if shard {
+		sharder = &sharding.Sharder{
+			Client:        mgr.GetClient(),
+			Reader:        mgr.GetAPIReader(),
+			Log:           ctrl.Log.WithName("sharder"),
+			Identity:      shardIdentity,
+			Namespace:     shardLeaseNamespace,
+			LeaseDuration: shardLeaseDuration,
+			RenewInterval: shardRenewInterval,
+		}
+		if err := mgr.Add(sharder); err != nil {
+			setupLog.Error(err, "unable to create sharder")
+			os.Exit(1)
+		}
+		reconciler.Sharder = sharder
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ArgoCD")
 		os.Exit(1)
 	}
+	if sweepInterval > 0 {
+		if err := (&argocd.Sweeper{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("sweeper").WithName("ArgoCD"),
+			Namespaces: namespaces,
+			Interval:   sweepInterval,
+			DryRun:     sweepDryRun,
+			Sharder:    sharder,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create sweeper", "sweeper", "ArgoCD")
+			os.Exit(1)
+		}
+	}
+
+	if rollout {
+		if err := (&argocd.Rollout{
+			Client:            mgr.GetClient(),
+			Reader:            mgr.GetAPIReader(),
+			Log:               ctrl.Log.WithName("rollout").WithName("ArgoCD"),
+			Interval:          rolloutInterval,
+			BatchPercent:      rolloutBatchPercent,
+			HealthTimeout:     rolloutHealthTimeout,
+			Resolver:          resolver,
+			Mirrors:           mirrors,
+			ImageHistoryLimit: imageHistoryLimit,
+			Catalog:           imageCatalog,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create rollout", "rollout", "ArgoCD")
+			os.Exit(1)
+		}
+	}
+
+	// migrate the helm releases before the manager starts reconciling, the cache is not running yet therefore the API reader is used
+	if migrateHelmDriver != "" {
+		setupLog.Info("migrating helm releases", "from", migrateHelmDriver, "dryRun", migrateDryRun)
+		for _, namespace := range watchedNamespaces(namespaces) {
+			if err := reconciler.MigrateHelmDriver(context.Background(), mgr.GetAPIReader(), migrateHelmDriver, migrateDryRun, client.InNamespace(namespace)); err != nil {
+				setupLog.Error(err, "unable to migrate helm releases")
+				os.Exit(1)
+			}
+		}
+		if migrateDryRun {
+			os.Exit(0)
+		}
+	}
+
+	// record the namespaces of the deployed helm releases in the instances which did not record them yet, in order to
+	// not miss removed namespace labels. Shard replicas are not leader elected, they record the namespaces of their
+	// instances on the reconciliation after the handover.
+	if !shard {
+		if err := reconciler.SetupRestoreWithManager(mgr, watchedNamespaces(namespaces)); err != nil {
+			setupLog.Error(err, "unable to set up restore of bound namespaces")
+			os.Exit(1)
+		}
+	}
+
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
@@ -119,3 +439,11 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// watchedNamespaces returns the watched namespaces or a single empty namespace (all namespaces) if none are specified
+func watchedNamespaces(namespaces []string) []string {
+	if len(namespaces) == 0 {
+		return []string{""}
+	}
+	return namespaces
+}
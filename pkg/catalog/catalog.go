@@ -0,0 +1,158 @@
+package catalog
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// Catalog watches the ConfigMap which lists the target images of the ArgoCD components, the images of the
+// ARGOCD_IMAGE, DEX_IMAGE and REDIS_IMAGE environment variables are used for the components which it does not list.
+// If the images change, all the ArgoCD instances are enqueued in order that their update policies are applied.
+type Catalog struct {
+	client.Client
+	Log logr.Logger
+
+	// ListerWatcher lists and watches the catalog ConfigMap, see NewListWatch
+	ListerWatcher cache.ListerWatcher
+
+	mu     sync.RWMutex
+	images Images
+	synced bool
+	// queue of the controller the instances are enqueued to, it is only set on the replica running the controller
+	queue workqueue.RateLimitingInterface
+}
+
+// NewListWatch returns a ListerWatcher of the ConfigMap with the given name
+func NewListWatch(clientset kubernetes.Interface, key types.NamespacedName) cache.ListerWatcher {
+	return cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "configmaps", key.Namespace, fields.OneTermEqualSelector("metadata.name", key.Name))
+}
+
+// Source returns the source of the reconcile requests which are enqueued for every ArgoCD instance if the images change
+func (c *Catalog) Source() source.Source {
+	return &queueSource{catalog: c}
+}
+
+// queueSource enqueues the instances to the queue of the controller directly once the controller started, the catalog
+// runs on every replica but the controller only on the leader
+type queueSource struct {
+	catalog *Catalog
+}
+
+// Start implements the source.Source interface
+func (q *queueSource) Start(_ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+	q.catalog.mu.Lock()
+	defer q.catalog.mu.Unlock()
+
+	q.catalog.queue = queue
+	return nil
+}
+
+// Images returns the target images of the components and false if the ConfigMap was not loaded yet
+func (c *Catalog) Images() (Images, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return FromEnv().Merge(c.images), c.synced
+}
+
+// NeedLeaderElection implements the manager.LeaderElectionRunnable interface, every replica needs the catalog in
+// order to reconcile its ArgoCD instances
+func (c *Catalog) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements the manager.Runnable interface and watches the ConfigMap until the stop channel is closed
+func (c *Catalog) Start(stop <-chan struct{}) error {
+	update := func(obj interface{}) {
+		cm, _ := obj.(*corev1.ConfigMap)
+		if err := c.Update(context.Background(), cm); err != nil {
+			c.Log.Error(err, "unable to update image catalog")
+		}
+	}
+
+	_, informer := cache.NewInformer(c.ListerWatcher, &corev1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, obj interface{}) { update(obj) },
+		DeleteFunc: func(interface{}) { update(nil) },
+	})
+	go informer.Run(stop)
+
+	// the catalog is synced even if the ConfigMap does not exist
+	if cache.WaitForCacheSync(stop, informer.HasSynced) {
+		c.mu.Lock()
+		synced := c.synced
+		c.synced = true
+		c.mu.Unlock()
+
+		if !synced {
+			if err := c.enqueue(context.Background()); err != nil {
+				c.Log.Error(err, "unable to enqueue ArgoCD instances")
+			}
+		}
+	}
+
+	<-stop
+	return nil
+}
+
+// Update replaces the images by the ones of the ConfigMap (nil if it was deleted) and enqueues all the ArgoCD instances
+// if they changed. An invalid ConfigMap is rejected and the previous images are kept.
+func (c *Catalog) Update(ctx context.Context, cm *corev1.ConfigMap) error {
+	images := Images{}
+	if cm != nil {
+		var err error
+		if images, err = Parse(cm.Data); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	changed := !c.synced || !reflect.DeepEqual(c.images, images)
+	c.images = images
+	c.synced = true
+	c.mu.Unlock()
+
+	if changed {
+		c.Log.Info("image catalog changed, enqueue ArgoCD instances", "images", images)
+		return c.enqueue(ctx)
+	}
+	return nil
+}
+
+// enqueue enqueues every ArgoCD instance if the controller is running, otherwise it reconciles all of them once it
+// starts
+func (c *Catalog) enqueue(ctx context.Context) error {
+	c.mu.RLock()
+	queue := c.queue
+	c.mu.RUnlock()
+	if queue == nil {
+		return nil
+	}
+
+	list := argoprojv1alpha1.ArgoCDList{}
+	if err := c.List(ctx, &list); err != nil {
+		return err
+	}
+
+	for _, argocd := range list.Items {
+		queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: argocd.Namespace, Name: argocd.Name}})
+	}
+	return nil
+}
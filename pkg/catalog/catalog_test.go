@@ -0,0 +1,168 @@
+package catalog_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	logr "github.com/go-logr/logr/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
+)
+
+var _ = Describe("Catalog", func() {
+	It("should_not_be_synced_before_the_configmap_is_loaded", func() {
+		c := testCatalog(nil)
+		_, synced := c.Images()
+		Ω(synced).Should(BeFalse())
+	})
+	It("should_enqueue_all_instances_if_images_change", func() {
+		c := testCatalog(nil, testArgoCD("a"), testArgoCD("b"))
+		queue := testQueue(c)
+
+		Ω(c.Update(context.TODO(), testConfigMap(map[string]string{"dex": "dex:v2"}))).ShouldNot(HaveOccurred())
+		Ω(queue.Len()).Should(Equal(2))
+		testDone(queue, 2)
+
+		images, synced := c.Images()
+		Ω(synced).Should(BeTrue())
+		Ω(images).Should(HaveKeyWithValue(catalog.ComponentDex, "dex:v2"))
+
+		// unchanged images do not enqueue the instances again
+		Ω(c.Update(context.TODO(), testConfigMap(map[string]string{"dex": "dex:v2"}))).ShouldNot(HaveOccurred())
+		Ω(queue.Len()).Should(BeZero())
+	})
+	It("should_not_enqueue_instances_without_controller", func() {
+		var objects []runtime.Object
+		for i := 0; i < 2000; i++ {
+			objects = append(objects, testArgoCD(fmt.Sprintf("argocd-%d", i)))
+		}
+		c := testCatalog(nil, objects...)
+
+		// the catalog of a replica which is not the leader must not block on the instances
+		for _, version := range []string{"v2", "v3"} {
+			Ω(c.Update(context.TODO(), testConfigMap(map[string]string{"dex": "dex:" + version}))).ShouldNot(HaveOccurred())
+		}
+		images, _ := c.Images()
+		Ω(images).Should(HaveKeyWithValue(catalog.ComponentDex, "dex:v3"))
+	})
+	It("should_keep_images_if_configmap_is_invalid", func() {
+		c := testCatalog(nil)
+
+		Ω(c.Update(context.TODO(), testConfigMap(map[string]string{"dex": "dex:v2"}))).ShouldNot(HaveOccurred())
+		Ω(c.Update(context.TODO(), testConfigMap(map[string]string{"unknown": "dex:v3"}))).Should(HaveOccurred())
+
+		images, _ := c.Images()
+		Ω(images).Should(HaveKeyWithValue(catalog.ComponentDex, "dex:v2"))
+	})
+	It("should_watch_configmap", func() {
+		clientset := fake.NewSimpleClientset(testConfigMap(map[string]string{"redis": "redis:6"}))
+		c := testCatalog(clientset, testArgoCD("a"))
+		queue := testQueue(c)
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			defer GinkgoRecover()
+			Ω(c.Start(stop)).ShouldNot(HaveOccurred())
+		}()
+
+		Eventually(queue.Len).Should(Equal(1))
+		testDone(queue, 1)
+		Eventually(func() catalog.Images {
+			images, _ := c.Images()
+			return images
+		}).Should(HaveKeyWithValue(catalog.ComponentRedis, "redis:6"))
+
+		_, err := clientset.CoreV1().ConfigMaps("default").Update(context.TODO(), testConfigMap(map[string]string{"redis": "redis:6.2"}), metav1.UpdateOptions{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Eventually(queue.Len).Should(Equal(1))
+		Eventually(func() catalog.Images {
+			images, _ := c.Images()
+			return images
+		}).Should(HaveKeyWithValue(catalog.ComponentRedis, "redis:6.2"))
+	})
+	It("should_be_synced_if_configmap_does_not_exist", func() {
+		c := testCatalog(fake.NewSimpleClientset(), testArgoCD("a"))
+		queue := testQueue(c)
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			defer GinkgoRecover()
+			Ω(c.Start(stop)).ShouldNot(HaveOccurred())
+		}()
+
+		Eventually(queue.Len).Should(Equal(1))
+		_, synced := c.Images()
+		Ω(synced).Should(BeTrue())
+	})
+})
+
+func testCatalog(clientset *fake.Clientset, objects ...runtime.Object) *catalog.Catalog {
+	s := scheme.Scheme
+	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+	c := &catalog.Catalog{
+		Client: ctrlfake.NewFakeClientWithScheme(s, objects...),
+		Log:    logr.NullLogger{},
+	}
+	if clientset != nil {
+		// the fake clientset does not provide a REST client, hence the ConfigMaps are listed and watched directly
+		c.ListerWatcher = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return clientset.CoreV1().ConfigMaps("default").List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return clientset.CoreV1().ConfigMaps("default").Watch(context.TODO(), options)
+			},
+		}
+	}
+	return c
+}
+
+// testQueue starts the source of the catalog with a queue like the controller
+func testQueue(c *catalog.Catalog) workqueue.RateLimitingInterface {
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	Ω(c.Source().Start(nil, queue)).ShouldNot(HaveOccurred())
+	return queue
+}
+
+// testDone removes the given number of requests from the queue like the controller
+func testDone(queue workqueue.RateLimitingInterface, n int) {
+	for i := 0; i < n; i++ {
+		item, _ := queue.Get()
+		queue.Done(item)
+	}
+}
+
+func testConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "argocd-image-catalog",
+			Namespace: "default",
+		},
+		Data: data,
+	}
+}
+
+func testArgoCD(name string) runtime.Object {
+	return &argoprojv1alpha1.ArgoCD{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+	}
+}
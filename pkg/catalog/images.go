@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+)
+
+// Component of an ArgoCD instance with its own image
+type Component string
+
+const (
+	// ComponentArgoCD - image of the ArgoCD components (server, repo server, application controller)
+	ComponentArgoCD Component = "argocd"
+	// ComponentDex - image of the Dex server
+	ComponentDex Component = "dex"
+	// ComponentRedis - image of the Redis server
+	ComponentRedis Component = "redis"
+	// ComponentRedisHAProxy - image of the HAProxy in front of Redis in HA mode
+	ComponentRedisHAProxy Component = "redis-ha-proxy"
+	// ComponentGrafana - image of Grafana
+	ComponentGrafana Component = "grafana"
+	// ComponentApplicationSet - image of the ApplicationSet controller
+	ComponentApplicationSet Component = "applicationset"
+)
+
+// Components lists all the components of an ArgoCD instance which can be listed in the catalog. Prometheus is not
+// listed since its image is not part of the ArgoCD spec (it is managed by the Prometheus operator), hence a catalog
+// with a 'prometheus' entry is rejected instead of silently ignoring it.
+var Components = []Component{
+	ComponentArgoCD,
+	ComponentDex,
+	ComponentRedis,
+	ComponentRedisHAProxy,
+	ComponentGrafana,
+	ComponentApplicationSet,
+}
+
+// Images maps the components to their target image ([<image>][:<tag>][@<digest>])
+type Images map[Component]string
+
+// Parse parses the data of the catalog ConfigMap, the keys are the components and the values their target images
+func Parse(data map[string]string) (Images, error) {
+	ret := make(Images)
+	for key, value := range data {
+		component := Component(strings.TrimSpace(key))
+		if !component.valid() {
+			return nil, fmt.Errorf("unknown component '%s', allowed values are: %s", key, strings.Join(componentNames(), ", "))
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if _, err := image.Parse(value); err != nil {
+			return nil, fmt.Errorf("invalid image of component '%s': %w", key, err)
+		}
+		ret[component] = value
+	}
+	return ret, nil
+}
+
+// FromEnv returns the images of the ARGOCD_IMAGE, DEX_IMAGE and REDIS_IMAGE environment variables
+func FromEnv() Images {
+	ret := make(Images)
+	for component, env := range map[Component]string{
+		ComponentArgoCD: constants.EnvArgoCDImage,
+		ComponentDex:    constants.EnvDexImage,
+		ComponentRedis:  constants.EnvRedisImage,
+	} {
+		if value := os.Getenv(env); value != "" {
+			ret[component] = value
+		}
+	}
+	return ret
+}
+
+// Merge returns the images overridden by the images of the other catalog
+func (i Images) Merge(other Images) Images {
+	ret := make(Images, len(i)+len(other))
+	for component, value := range i {
+		ret[component] = value
+	}
+	for component, value := range other {
+		ret[component] = value
+	}
+	return ret
+}
+
+func (c Component) valid() bool {
+	for _, component := range Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+func componentNames() []string {
+	var ret []string
+	for _, component := range Components {
+		ret = append(ret, string(component))
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,61 @@
+package catalog_test
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/catalog"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+)
+
+var _ = Describe("Images", func() {
+	Context("Parse", func() {
+		It("should_parse_images_of_all_components", func() {
+			images, err := catalog.Parse(map[string]string{
+				"argocd":         "quay.io/argoproj/argocd:v2.0.5",
+				"dex":            "ghcr.io/dexidp/dex:v2.30.0",
+				"redis":          "redis:6.2.4-alpine",
+				"redis-ha-proxy": "haproxy:2.0.22-alpine",
+				"grafana":        "grafana/grafana:8.0.3",
+				"applicationset": "registry:5000/argoproj/applicationset:v0.2.0",
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(images).Should(HaveLen(len(catalog.Components)))
+			Ω(images[catalog.ComponentRedisHAProxy]).Should(Equal("haproxy:2.0.22-alpine"))
+		})
+		It("should_ignore_empty_images", func() {
+			images, err := catalog.Parse(map[string]string{"argocd": " "})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(images).Should(BeEmpty())
+		})
+		It("should_reject_unknown_component", func() {
+			_, err := catalog.Parse(map[string]string{"argo-cd": "argoproj/argocd:v2.0.5"})
+			Ω(err).Should(HaveOccurred())
+		})
+		It("should_reject_unsupported_component", func() {
+			_, err := catalog.Parse(map[string]string{"prometheus": "quay.io/prometheus/prometheus:v2.28.0"})
+			Ω(err).Should(HaveOccurred())
+		})
+		It("should_reject_invalid_image", func() {
+			_, err := catalog.Parse(map[string]string{"argocd": "argoproj/argocd:v2/0"})
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+	Context("FromEnv", func() {
+		AfterEach(func() {
+			Ω(os.Unsetenv(constants.EnvArgoCDImage)).ShouldNot(HaveOccurred())
+		})
+		It("should_return_images_of_environment_variables", func() {
+			Ω(os.Setenv(constants.EnvArgoCDImage, "argoproj/argocd:v2.0.5")).ShouldNot(HaveOccurred())
+			Ω(catalog.FromEnv()).Should(Equal(catalog.Images{catalog.ComponentArgoCD: "argoproj/argocd:v2.0.5"}))
+		})
+	})
+	Context("Merge", func() {
+		It("should_override_images", func() {
+			images := catalog.Images{catalog.ComponentArgoCD: "argocd:v1", catalog.ComponentDex: "dex:v1"}
+			Ω(images.Merge(catalog.Images{catalog.ComponentArgoCD: "argocd:v2"})).Should(Equal(catalog.Images{catalog.ComponentArgoCD: "argocd:v2", catalog.ComponentDex: "dex:v1"}))
+		})
+	})
+})
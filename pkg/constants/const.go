@@ -2,24 +2,114 @@ package constants
 
 const (
 	// AnnotationImageVersionUpdatePolicy - specify the update policy of the images and versions,
-	// allowed values are: 'None', 'Always' or 'IfNotPresent' (default: 'None')
+	// allowed values are: 'None', 'Always', 'IfNotPresent' or 'Semver' (default: 'None'). The policy of a single
+	// component is overridden by the annotation suffixed with '.<component>' (e.g. 'argocd.snorwin.io/image-update-policy.redis')
 	AnnotationImageVersionUpdatePolicy = "argocd.snorwin.io/image-update-policy"
+	// AnnotationImageVersionConstraint - semantic version constraint (e.g. '~2.0' or '>=2.0 <3') of the ArgoCD version for the 'Semver' update policy,
+	// the constraint of another component is set by the annotation suffixed with '.<component>'
+	// (e.g. 'argocd.snorwin.io/image-update-constraint.redis')
+	AnnotationImageVersionConstraint = "argocd.snorwin.io/image-update-constraint"
+	// AnnotationSkippedImageUpdates - comma separated list of the images (<image>:<version>) which were not applied by the 'Semver' update policy
+	AnnotationSkippedImageUpdates = "argocd.snorwin.io/skipped-image-updates"
+	// AnnotationImageHistory - set by the extension: JSON list of the images of the components before the latest image updates
+	AnnotationImageHistory = "argocd.snorwin.io/image-history"
+	// AnnotationImageRevert - set to 'true' in order to restore the images of the latest image history entry, pauses the image updates
+	AnnotationImageRevert = "argocd.snorwin.io/image-revert"
+	// AnnotationImageUpdatesPaused - the image update policies are not applied while it is 'true', set by a revert
+	AnnotationImageUpdatesPaused = "argocd.snorwin.io/image-updates-paused"
+	// AnnotationMaintenanceWindow - maintenance windows in which the image updates are applied, overrides the global MAINTENANCE_WINDOW
+	AnnotationMaintenanceWindow = "argocd.snorwin.io/maintenance-window"
+	// AnnotationRolloutStarted - time (RFC3339) the staged rollout updated the ArgoCD image of this instance, removed once it is healthy
+	AnnotationRolloutStarted = "argocd.snorwin.io/rollout-started"
+	// AnnotationRolloutFailed - ArgoCD image (<image>:<version>) which did not become healthy on this instance, halts the staged rollout
+	AnnotationRolloutFailed = "argocd.snorwin.io/rollout-failed"
 	// AnnotationHelmHash - hash to track the helm chart and values installed for this ArgoCD instance
 	AnnotationHelmHash = "argocd.snorwin.io/helm-hash"
+	// AnnotationNamespaces - comma separated list of the namespaces bound to this ArgoCD instance by the installed helm chart
+	AnnotationNamespaces = "argocd.snorwin.io/namespaces"
+	// AnnotationPaused - suspend all actions of the extension (image updates, helm upgrades and finalizer) on this instance while it is 'true'
+	AnnotationPaused = "argocd.snorwin.io/paused"
+	// AnnotationPausedBy - optional name of the person or reason which paused this instance, reported in the 'Paused' event
+	AnnotationPausedBy = "argocd.snorwin.io/paused-by"
+	// AnnotationAppProjects - manage an AppProject per bound namespace whose destinations are restricted to the namespace,
+	// allowed values are: 'true' or 'false' (deletes the managed projects)
+	AnnotationAppProjects = "argocd.snorwin.io/app-projects"
+	// AnnotationRestrictDefaultAppProject - remove all destinations of the 'default' AppProject in order that only the
+	// managed projects can deploy, requires the app-projects annotation, allowed values are: 'true' or 'false' (default: 'false')
+	AnnotationRestrictDefaultAppProject = "argocd.snorwin.io/restrict-default-app-project"
+	// AnnotationNetworkPolicies - render the NetworkPolicies of the helm chart which restrict the traffic of ArgoCD to the
+	// bound namespaces, allowed values are: 'true' or 'false' (default: 'false')
+	AnnotationNetworkPolicies = "argocd.snorwin.io/network-policies"
+	// AnnotationTenantNetworkPolicies - render the NetworkPolicies of the helm chart which isolate the ingress of the
+	// bound namespaces as well, requires the network-policies annotation, allowed values are: 'true' or 'false' (default: 'false')
+	AnnotationTenantNetworkPolicies = "argocd.snorwin.io/tenant-network-policies"
+	// AnnotationRBACPolicy - generate the Argo CD RBAC policy from the RoleBindings of the bound namespaces into the target,
+	// allowed values are: 'spec' (spec.rbac.policy) or 'configmap' (argocd-rbac-cm)
+	AnnotationRBACPolicy = "argocd.snorwin.io/rbac-policy"
+	// AnnotationRemoteClusters - comma separated list of the Secrets (in the namespace of the ArgoCD instance) with the
+	// kubeconfig and the namespaces of the remote clusters which are registered as destinations of the instance
+	AnnotationRemoteClusters = "argocd.snorwin.io/remote-clusters"
+	// AnnotationForceRemoveFinalizer - remove the finalizer even if the helm chart cannot be uninstalled, allowed values are: 'true' or 'false' (default: 'false')
+	AnnotationForceRemoveFinalizer = "argocd.snorwin.io/force-remove-finalizer"
 
 	// ImageVersionUpdatePolicy
 	ImageVersionUpdatePolicyNone         = "None"
 	ImageVersionUpdatePolicyAlways       = "Always"
 	ImageVersionUpdatePolicyIfNotPresent = "IfNotPresent"
+	ImageVersionUpdatePolicySemver       = "Semver"
+
+	// RBACPolicyTarget
+	RBACPolicyTargetSpec      = "spec"
+	RBACPolicyTargetConfigMap = "configmap"
 
 	// LabelArgoCDName - namespace label to specify the ArgoCD name
 	LabelArgoCDName = "argocd.snorwin.io/name"
 	// LabelArgoCDNamespace - namespace label to specify the ArgoCD namespace
 	LabelArgoCDNamespace = "argocd.snorwin.io/namespace"
+	// LabelCanary - label of the ArgoCD instances which receive new ArgoCD images first during a staged rollout
+	LabelCanary = "argocd.snorwin.io/canary"
+	// LabelManagedBy - label of the objects managed by the extension for an ArgoCD instance, the value is the ArgoCD name.
+	// The objects outside the namespace of the instance are labelled with LabelArgoCDNamespace as well.
+	LabelManagedBy = "argocd.snorwin.io/managed-by"
+	// LabelShard - label of the Lease objects held by the replicas of the extension for the sharding
+	LabelShard = "argocd.snorwin.io/shard"
 
 	// FinalizerName - name of the finalizer added to the ArgoCD instance
 	FinalizerName = "uninstall.finalizers.argocd.snorwin.io"
 
+	// EventReasonUninstallFailed - event reason if the helm chart of a deleted ArgoCD instance cannot be uninstalled
+	EventReasonUninstallFailed = "UninstallFailed"
+	// EventReasonFinalizerForceRemoved - event reason if the finalizer was removed without uninstalling the helm chart
+	EventReasonFinalizerForceRemoved = "FinalizerForceRemoved"
+	// EventReasonAppProjectConflict - event reason if an AppProject which is not managed by the extension has the name of a bound namespace
+	EventReasonAppProjectConflict = "AppProjectConflict"
+	// EventReasonDefaultAppProjectRestricted - event reason if the destinations of the 'default' AppProject were removed
+	EventReasonDefaultAppProjectRestricted = "DefaultAppProjectRestricted"
+	// EventReasonRemoteClusterFailed - event reason if a remote cluster cannot be registered or removed
+	EventReasonRemoteClusterFailed = "RemoteClusterFailed"
+	// EventReasonPaused - event reason if the reconciliation of a paused instance was skipped
+	EventReasonPaused = "Paused"
+	// EventReasonChartLoadFailed - event reason if the helm chart cannot be loaded
+	EventReasonChartLoadFailed = "ChartLoadFailed"
+	// EventReasonRolloutStarted - event reason if the staged rollout updated the ArgoCD image of an instance
+	EventReasonRolloutStarted = "RolloutStarted"
+	// EventReasonRolloutFailed - event reason if an instance did not become healthy after the staged rollout updated it
+	EventReasonRolloutFailed = "RolloutFailed"
+	// EventReasonImageVerificationFailed - event reason if the target image of an update does not exist in its registry
+	EventReasonImageVerificationFailed = "ImageVerificationFailed"
+	// EventReasonImagesReverted - event reason if the images of an instance were reverted to the latest image history entry
+	EventReasonImagesReverted = "ImagesReverted"
+	// EventReasonImageUpdateSkipped - event reason if an image update was not applied by the 'Semver' update policy
+	EventReasonImageUpdateSkipped = "ImageUpdateSkipped"
+	// EventReasonInvalidImageUpdateConstraint - event reason if an image update was skipped due to an invalid semantic version constraint
+	EventReasonInvalidImageUpdateConstraint = "InvalidImageUpdateConstraint"
+	// EventReasonInvalidMaintenanceWindow - event reason if the image updates were deferred due to an invalid maintenance window
+	EventReasonInvalidMaintenanceWindow = "InvalidMaintenanceWindow"
+
+	// EnvWatchNamespace - comma separated list of namespaces which are watched, all namespaces are watched if empty
+	EnvWatchNamespace = "WATCH_NAMESPACE"
+	// EnvWatchNamespaceSelector - label selector of the namespaces which are watched in addition to WATCH_NAMESPACE
+	EnvWatchNamespaceSelector = "WATCH_NAMESPACE_SELECTOR"
 	// EnvHelmDriver - helm storage driver (default: secret)
 	EnvHelmDriver = "HELM_DRIVER"
 	// EnvHelmMaxHistory - limit the maximum number of revisions saved per release. Use 0 for no limit. Default 10
@@ -28,10 +118,20 @@ const (
 	EnvHelmDirectory = "HELM_DIRECTORY"
 	// EnvClusterArgoCDNamespacedNames - comma separated list of NamespacedNames (namespace/name) of ArgoCD instances which run in cluster mode
 	EnvClusterArgoCDNamespacedNames = "CLUSTER_ARGOCD_NAMESPACEDNAMES"
+	// EnvImageCatalog - NamespacedName (namespace/name) of the ConfigMap which lists the target images of the ArgoCD components
+	EnvImageCatalog = "IMAGE_CATALOG"
 	// EnvArgoCDImage - ArgoCD image and version (<image>:<version>) used for automated version updates
 	EnvArgoCDImage = "ARGOCD_IMAGE"
 	// EnvDexImage - Dex image and version (<image>:<version>) used for automated version updates
 	EnvDexImage = "DEX_IMAGE"
 	// EnvRedisImage - Redis image and version (<image>:<version>) used for automated version updates
 	EnvRedisImage = "REDIS_IMAGE"
+	// EnvRegistryMirrors - comma separated list of rules '<from>=<to>' which rewrite the registries of the images (e.g. 'quay.io/=mirror.corp/quay/')
+	EnvRegistryMirrors = "REGISTRY_MIRRORS"
+	// EnvMaintenanceWindow - global maintenance windows in which the image updates are applied (default: always)
+	EnvMaintenanceWindow = "MAINTENANCE_WINDOW"
+	// EnvPodName - name of the pod of the extension, used as identity for the sharding
+	EnvPodName = "POD_NAME"
+	// EnvPodNamespace - namespace of the pod of the extension, used for the Lease objects of the sharding
+	EnvPodNamespace = "POD_NAMESPACE"
 )
@@ -1,10 +1,18 @@
 package helm
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/storage/driver"
 )
 
@@ -13,6 +21,8 @@ type Client interface {
 	Install(release string, chart *chart.Chart, values chartutil.Values) error
 	Upgrade(release string, chart *chart.Chart, values chartutil.Values, install bool) error
 	Uninstall(release string) error
+	Migrate(release string, driver string, dryRun bool) (int, error)
+	List() ([]*release.Release, error)
 }
 
 // ClientFactory provides an abstraction to create a new namespaced Client
@@ -22,6 +32,7 @@ type ClientFactory func(namespace string, options ...ClientOption) (Client, erro
 type client struct {
 	action.Configuration
 
+	ctx        context.Context
 	maxHistory int
 	namespace  string
 	driver     string
@@ -30,7 +41,7 @@ type client struct {
 
 // NewClientForNamespace is a ClientFactory
 func NewClientForNamespace(namespace string, options ...ClientOption) (Client, error) {
-	c := &client{namespace: namespace}
+	c := &client{namespace: namespace, ctx: context.Background()}
 
 	// apply the ClientOptions to the client
 	for _, option := range options {
@@ -47,6 +58,14 @@ func NewClientForNamespace(namespace string, options ...ClientOption) (Client, e
 
 // Install creates a action.Install with the client configuration and installs the given Helm chart and values
 func (c *client) Install(release string, chart *chart.Chart, values chartutil.Values) error {
+	return c.install(c.ctx, release, chart, values)
+}
+
+// install installs the given Helm chart and values with a trace span as child of the given context
+func (c *client) install(ctx context.Context, release string, chart *chart.Chart, values chartutil.Values) (err error) {
+	_, span := tracing.Start(ctx, "helm.Install", c.attributes(release)...)
+	defer func() { tracing.End(span, err) }()
+
 	install := action.NewInstall(&c.Configuration)
 	install.ReleaseName = release
 	install.Namespace = c.namespace
@@ -57,9 +76,16 @@ func (c *client) Install(release string, chart *chart.Chart, values chartutil.Va
 }
 
 // Upgrade creates a action.Upgrade with the client configuration and upgrades or installs (if the install flag is set) the given Helm chart and values
-func (c *client) Upgrade(release string, chart *chart.Chart, values chartutil.Values, install bool) error {
-	if _, err := action.NewStatus(&c.Configuration).Run(release); err == driver.ErrReleaseNotFound && install {
-		return c.Install(release, chart, values)
+func (c *client) Upgrade(release string, chart *chart.Chart, values chartutil.Values, install bool) (err error) {
+	ctx, span := c.start("helm.Upgrade", release)
+	defer func() { tracing.End(span, err) }()
+
+	_, statusSpan := tracing.Start(ctx, "helm.Status", c.attributes(release)...)
+	_, err = action.NewStatus(&c.Configuration).Run(release)
+	statusSpan.End()
+
+	if err == driver.ErrReleaseNotFound && install {
+		return c.install(ctx, release, chart, values)
 	} else if err == nil {
 		upgrade := action.NewUpgrade(&c.Configuration)
 		upgrade.MaxHistory = c.maxHistory
@@ -73,7 +99,10 @@ func (c *client) Upgrade(release string, chart *chart.Chart, values chartutil.Va
 }
 
 // Uninstall creates a action.Uninstall with the client configuration and uninstalls the given release
-func (c *client) Uninstall(release string) error {
+func (c *client) Uninstall(release string) (err error) {
+	_, span := c.start("helm.Uninstall", release)
+	defer func() { tracing.End(span, err) }()
+
 	if _, err := action.NewUninstall(&c.Configuration).Run(release); err != nil {
 		if err == driver.ErrReleaseNotFound {
 			err = nil
@@ -82,3 +111,89 @@ func (c *client) Uninstall(release string) error {
 	}
 	return nil
 }
+
+// Migrate moves all revisions of the given release from the storage driver 'from' to the storage driver of the client
+// configuration. If dryRun is set nothing is moved. It returns the number of revisions which are (or would be) moved.
+func (c *client) Migrate(release string, from string, dryRun bool) (_ int, err error) {
+	_, span := c.start("helm.Migrate", release)
+	defer func() { tracing.End(span, err) }()
+
+	if storageDriver(from) == storageDriver(c.driver) {
+		return 0, fmt.Errorf("unable to migrate release %s: source and target storage driver are both '%s'", release, storageDriver(from))
+	}
+	if storageDriver(from) == "memory" || storageDriver(c.driver) == "memory" {
+		return 0, fmt.Errorf("unable to migrate release %s: the 'memory' storage driver is not persistent", release)
+	}
+
+	// initialize a client configuration for the source storage driver
+	source := action.Configuration{}
+	if err := source.Init(cli.New().RESTClientGetter(), c.namespace, from, c.logger); err != nil {
+		return 0, err
+	}
+
+	history, err := source.Releases.History(release)
+	if err == driver.ErrReleaseNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if dryRun {
+		return len(history), nil
+	}
+
+	// copy all revisions first and ignore the ones which already exist in the target storage
+	for _, rls := range history {
+		if err := c.Releases.Create(rls); err != nil && err != driver.ErrReleaseExists {
+			return 0, err
+		}
+	}
+
+	// remove the revisions from the source storage once all of them are copied and ignore the ones which were already
+	// removed (e.g. by another replica migrating at the same time)
+	for _, rls := range history {
+		if _, err := source.Releases.Delete(rls.Name, rls.Version); err != nil && err != driver.ErrReleaseNotFound {
+			return 0, err
+		}
+	}
+
+	return len(history), nil
+}
+
+// List creates a action.List with the client configuration and returns the latest revision of all releases in any state,
+// if the namespace of the client is empty the releases of all namespaces are returned
+func (c *client) List() (_ []*release.Release, err error) {
+	_, span := c.start("helm.List", "")
+	defer func() { tracing.End(span, err) }()
+
+	list := action.NewList(&c.Configuration)
+	list.AllNamespaces = c.namespace == ""
+	list.All = true
+	list.SetStateMask()
+	return list.Run()
+}
+
+// start creates a trace span for a Helm operation as child of the client context
+func (c *client) start(name string, release string) (context.Context, trace.Span) {
+	return tracing.Start(c.ctx, name, c.attributes(release)...)
+}
+
+// attributes returns the trace span attributes of a release
+func (c *client) attributes(release string) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{attribute.String("namespace", c.namespace)}
+	if release != "" {
+		attributes = append(attributes, attribute.String("release", release), tracing.ArgoCD(c.namespace+"/"+release))
+	}
+	return attributes
+}
+
+// storageDriver normalizes the name of a Helm storage driver
+func storageDriver(name string) string {
+	switch name {
+	case "", "secret", "secrets":
+		return "secret"
+	case "configmap", "configmaps":
+		return "configmap"
+	}
+	return name
+}
@@ -1,6 +1,7 @@
 package helm
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/go-logr/logr"
@@ -31,3 +32,10 @@ func WithLogger(logger logr.Logger) ClientOption {
 		}
 	}
 }
+
+// WithContext sets the context of the client configuration which is used as parent of the trace spans
+func WithContext(ctx context.Context) ClientOption {
+	return func(c *client) {
+		c.ctx = ctx
+	}
+}
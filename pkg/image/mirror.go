@@ -0,0 +1,74 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rule rewrites the repositories starting with the prefix From to the prefix To, e.g. 'quay.io/' to 'mirror.corp/quay/'
+type Rule struct {
+	From string
+	To   string
+}
+
+// Rules is a list of rewrite rules of which the one with the longest matching prefix is applied
+type Rules []Rule
+
+// ParseRules parses a comma separated list of rewrite rules '<from>=<to>' (e.g. 'quay.io/=mirror.corp/quay/'). The
+// prefixes are matched against the repository as written and against its normalized name, hence 'docker.io/' matches
+// 'redis' as 'docker.io/library/redis'.
+func ParseRules(value string) (Rules, error) {
+	var ret Rules
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		split := strings.SplitN(s, "=", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" || strings.TrimSpace(split[1]) == "" {
+			return nil, fmt.Errorf("invalid registry mirror rule '%s', expected <from>=<to>", s)
+		}
+		rule := Rule{From: strings.TrimSpace(split[0]), To: strings.TrimSpace(split[1])}
+		if !anchoredRepository.MatchString(strings.TrimSuffix(rule.To, "/")) {
+			return nil, fmt.Errorf("invalid registry mirror rule '%s': invalid repository prefix '%s'", s, rule.To)
+		}
+		ret = append(ret, rule)
+	}
+
+	// the longest prefix takes precedence
+	sort.SliceStable(ret, func(i, j int) bool {
+		return len(ret[i].From) > len(ret[j].From)
+	})
+	return ret, nil
+}
+
+// Rewrite returns the reference with the repository rewritten by the rule with the longest matching prefix, the
+// reference is returned unchanged if no rule matches, it has no repository or it is already located on a mirror
+func (r Rules) Rewrite(ref Reference) Reference {
+	if ref.Repository == "" || r.Mirrored(ref) {
+		return ref
+	}
+
+	names := []string{ref.Repository, ref.Domain() + "/" + ref.Path()}
+	for _, rule := range r {
+		for _, name := range names {
+			if strings.HasPrefix(name, rule.From) {
+				ref.Repository = rule.To + strings.TrimPrefix(name, rule.From)
+				return ref
+			}
+		}
+	}
+	return ref
+}
+
+// Mirrored returns true if the repository of the reference is already located on a mirror of the rules
+func (r Rules) Mirrored(ref Reference) bool {
+	for _, rule := range r {
+		if strings.HasPrefix(ref.Repository, rule.To) {
+			return true
+		}
+	}
+	return false
+}
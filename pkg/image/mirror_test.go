@@ -0,0 +1,41 @@
+package image_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+)
+
+var _ = Describe("Rules", func() {
+	rules, err := image.ParseRules("quay.io/=mirror.corp/quay/, docker.io/=mirror.corp/dockerhub/, quay.io/argoproj/=mirror.corp/argoproj/")
+	It("should_parse_rules", func() {
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(rules).Should(HaveLen(3))
+	})
+	table.DescribeTable("Rewrite",
+		func(value string, expected string) {
+			ref, err := image.Parse(value)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(rules.Rewrite(ref).String()).Should(Equal(expected))
+		},
+		table.Entry("prefix", "quay.io/dexidp/dex:v2.30.0", "mirror.corp/quay/dexidp/dex:v2.30.0"),
+		table.Entry("longest_prefix", "quay.io/argoproj/argocd:v2.0.5", "mirror.corp/argoproj/argocd:v2.0.5"),
+		table.Entry("official_image", "redis:6.2.4", "mirror.corp/dockerhub/library/redis:6.2.4"),
+		table.Entry("docker_hub", "argoproj/argocd@"+testDigest, "mirror.corp/dockerhub/argoproj/argocd@"+testDigest),
+		table.Entry("explicit_docker_hub", "docker.io/argoproj/argocd", "mirror.corp/dockerhub/argoproj/argocd"),
+		table.Entry("no_match", "ghcr.io/dexidp/dex:v2.30.0", "ghcr.io/dexidp/dex:v2.30.0"),
+		table.Entry("already_mirrored", "mirror.corp/quay/dexidp/dex", "mirror.corp/quay/dexidp/dex"),
+		table.Entry("no_repository", ":v2.0.5", ":v2.0.5"),
+	)
+	table.DescribeTable("ParseRules_invalid",
+		func(value string) {
+			_, err := image.ParseRules(value)
+			Ω(err).Should(HaveOccurred())
+		},
+		table.Entry("missing_target", "quay.io/"),
+		table.Entry("empty_source", "=mirror.corp/"),
+		table.Entry("invalid_target", "quay.io/=Mirror.Corp/"),
+	)
+})
@@ -0,0 +1,110 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// defaultDomain - registry of the references without domain
+	defaultDomain = "docker.io"
+	// officialRepositoryPrefix - path prefix of the official images on the default registry
+	officialRepositoryPrefix = "library/"
+)
+
+var (
+	anchoredRepository = regexp.MustCompile(`^` + reference.NameRegexp.String() + `$`)
+	anchoredTag        = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+)
+
+// Reference is a container image reference '[<repository>][:<tag>][@<digest>]', the repository may contain a registry
+// with port (e.g. 'registry:5000/argoproj/argocd:v2.0.5' or 'argoproj/argocd@sha256:<hex>')
+type Reference struct {
+	// Repository - name of the image including the registry, empty if only the tag or digest is set
+	Repository string
+	// Tag - tag of the image
+	Tag string
+	// Digest - digest of the image manifest (e.g. 'sha256:<hex>')
+	Digest string
+}
+
+// Parse parses an image reference, the tag is the part after the last colon following the last slash and the digest
+// the part after the '@'
+func Parse(value string) (Reference, error) {
+	ref := Reference{}
+
+	name := value
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+		if _, err := digest.Parse(ref.Digest); err != nil {
+			return Reference{}, fmt.Errorf("invalid image reference '%s': %w", value, err)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+		if !anchoredTag.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid image reference '%s': invalid tag '%s'", value, ref.Tag)
+		}
+	}
+	if name != "" && !anchoredRepository.MatchString(name) {
+		return Reference{}, fmt.Errorf("invalid image reference '%s': invalid repository '%s'", value, name)
+	}
+	ref.Repository = name
+
+	return ref, nil
+}
+
+// Version returns the digest if the reference is pinned, otherwise the tag. It matches the 'version' fields of the
+// ArgoCD spec which are combined with the image by the argocd-operator as '<image>@<digest>' or '<image>:<tag>'.
+func (r Reference) Version() string {
+	if r.Pinned() {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// Pinned returns true if the reference contains a digest
+func (r Reference) Pinned() bool {
+	return r.Digest != ""
+}
+
+// String returns the reference in the form '[<repository>][:<tag>][@<digest>]'
+func (r Reference) String() string {
+	ret := r.Repository
+	if r.Tag != "" {
+		ret += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		ret += "@" + r.Digest
+	}
+	return ret
+}
+
+// Domain returns the registry of the repository, references without registry point to Docker Hub
+func (r Reference) Domain() string {
+	domain, _ := r.split()
+	return domain
+}
+
+// Path returns the path of the repository within its registry
+func (r Reference) Path() string {
+	_, path := r.split()
+	return path
+}
+
+// split splits the repository into registry and path the same way as the Docker CLI, the first component is a registry
+// if it contains a '.' or ':' or is 'localhost'
+func (r Reference) split() (string, string) {
+	i := strings.Index(r.Repository, "/")
+	if i < 0 || !strings.ContainsAny(r.Repository[:i], ".:") && r.Repository[:i] != "localhost" {
+		if i < 0 {
+			return defaultDomain, officialRepositoryPrefix + r.Repository
+		}
+		return defaultDomain, r.Repository
+	}
+	return r.Repository[:i], r.Repository[i+1:]
+}
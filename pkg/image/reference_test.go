@@ -0,0 +1,62 @@
+package image_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+)
+
+const testDigest = "sha256:4d70e3f8e9a7b4cf5c1f3ff2e0f4d3bdbd5f0e8b1d22c6e6fca7b8a9a6c5e4d3"
+
+var _ = Describe("Reference", func() {
+	table.DescribeTable("Parse",
+		func(value string, expected image.Reference) {
+			ref, err := image.Parse(value)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ref).Should(Equal(expected))
+			Ω(ref.String()).Should(Equal(value))
+		},
+		table.Entry("image", "argocd", image.Reference{Repository: "argocd"}),
+		table.Entry("image_and_tag", "argoproj/argocd:v2.0.5", image.Reference{Repository: "argoproj/argocd", Tag: "v2.0.5"}),
+		table.Entry("tag_only", ":v2.0.5", image.Reference{Tag: "v2.0.5"}),
+		table.Entry("registry_with_port", "registry:5000/argocd", image.Reference{Repository: "registry:5000/argocd"}),
+		table.Entry("registry_with_port_and_tag", "registry:5000/argocd:v2", image.Reference{Repository: "registry:5000/argocd", Tag: "v2"}),
+		table.Entry("digest", "quay.io/argoproj/argocd@"+testDigest, image.Reference{Repository: "quay.io/argoproj/argocd", Digest: testDigest}),
+		table.Entry("digest_only", "@"+testDigest, image.Reference{Digest: testDigest}),
+		table.Entry("tag_and_digest", "registry:5000/argocd:v2@"+testDigest, image.Reference{Repository: "registry:5000/argocd", Tag: "v2", Digest: testDigest}),
+	)
+	table.DescribeTable("Parse_invalid",
+		func(value string) {
+			_, err := image.Parse(value)
+			Ω(err).Should(HaveOccurred())
+		},
+		table.Entry("uppercase_repository", "ArgoCD:v2"),
+		table.Entry("invalid_tag", "argocd:v2/1"),
+		table.Entry("invalid_digest", "argocd@sha256:xyz"),
+	)
+	table.DescribeTable("Version",
+		func(value string, expected string) {
+			ref, err := image.Parse(value)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ref.Version()).Should(Equal(expected))
+		},
+		table.Entry("tag", "argocd:v2", "v2"),
+		table.Entry("digest", "argocd@"+testDigest, testDigest),
+		table.Entry("digest_before_tag", "argocd:v2@"+testDigest, testDigest),
+	)
+	table.DescribeTable("Domain_and_Path",
+		func(value string, domain string, path string) {
+			ref, err := image.Parse(value)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ref.Domain()).Should(Equal(domain))
+			Ω(ref.Path()).Should(Equal(path))
+		},
+		table.Entry("official_image", "redis:6", "docker.io", "library/redis"),
+		table.Entry("docker_hub", "argoproj/argocd:v2", "docker.io", "argoproj/argocd"),
+		table.Entry("registry", "ghcr.io/dexidp/dex:v2", "ghcr.io", "dexidp/dex"),
+		table.Entry("registry_with_port", "registry:5000/argoproj/argocd", "registry:5000", "argoproj/argocd"),
+		table.Entry("localhost", "localhost/argocd", "localhost", "argocd"),
+	)
+})
@@ -0,0 +1,250 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const (
+	// dockerHubRegistry - host of the registry API of Docker Hub
+	dockerHubRegistry = "registry-1.docker.io"
+	// headerContentDigest - response header of the registry API with the digest of the manifest
+	headerContentDigest = "Docker-Content-Digest"
+	// defaultTimeout - timeout of the requests to the registries if no client is set
+	defaultTimeout = 30 * time.Second
+)
+
+// defaultClient is used for the requests to the registries if no client is set, the reconciliations must not be blocked
+// by registries which do not respond
+var defaultClient = &http.Client{Timeout: defaultTimeout}
+
+// manifestMediaTypes - media types of the manifests which are accepted, the image indexes are preferred in order to
+// resolve to the same digest as the container runtime
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// ErrNotFound is returned if the manifest of an image does not exist in the registry
+var ErrNotFound = errors.New("image not found")
+
+// Resolver resolves image references to the digest of their manifest
+type Resolver interface {
+	// Resolve returns the reference pinned to the digest of the manifest its tag points to, a reference which is
+	// already pinned is verified to exist. The error wraps ErrNotFound if the manifest does not exist.
+	Resolve(ctx context.Context, ref Reference) (Reference, error)
+}
+
+// RegistryResolver resolves image references against the Docker Registry HTTP API V2, it supports anonymous access
+// with bearer tokens (e.g. Docker Hub or GHCR) and caches the resolved digests
+type RegistryResolver struct {
+	// Client is the HTTP client used for the requests, a client with a timeout of 30s is used if it is not set
+	Client *http.Client
+	// PlainHTTP is a list of registries (<host>[:<port>]) which are accessed with HTTP instead of HTTPS
+	PlainHTTP []string
+	// TTL of the resolved digests, tags are resolved on every call if it is zero
+	TTL time.Duration
+	// Clock is used to expire the cached digests
+	Clock clock.PassiveClock
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	digest  string
+	expires time.Time
+}
+
+// Resolve implements the Resolver interface
+func (r *RegistryResolver) Resolve(ctx context.Context, ref Reference) (Reference, error) {
+	if ref.Repository == "" {
+		return Reference{}, fmt.Errorf("unable to resolve image '%s' without repository", ref)
+	}
+
+	target := ref.Version()
+	if target == "" {
+		target = "latest"
+	}
+
+	key := ref.Repository + "@" + target
+	if d, ok := r.cached(key); ok {
+		return Reference{Repository: ref.Repository, Tag: ref.Tag, Digest: d}, nil
+	}
+
+	d, err := r.manifestDigest(ctx, ref.Domain(), ref.Path(), target)
+	if err != nil {
+		return Reference{}, fmt.Errorf("unable to resolve image '%s': %w", ref, err)
+	}
+	if ref.Pinned() && d != ref.Digest {
+		return Reference{}, fmt.Errorf("unable to verify image '%s': registry returned digest '%s'", ref, d)
+	}
+	r.store(key, d)
+
+	return Reference{Repository: ref.Repository, Tag: ref.Tag, Digest: d}, nil
+}
+
+// manifestDigest requests the manifest of the tag or digest and returns its digest
+func (r *RegistryResolver) manifestDigest(ctx context.Context, domain, path, target string) (string, error) {
+	scheme, host := "https", domain
+	if domain == defaultDomain {
+		host = dockerHubRegistry
+	}
+	for _, registry := range r.PlainHTTP {
+		if registry == domain {
+			scheme = "http"
+		}
+	}
+	endpoint := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, path, target)
+
+	resp, err := r.do(ctx, http.MethodHead, endpoint, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	// retry with an anonymous bearer token if the registry requires one
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := r.token(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = r.do(ctx, http.MethodHead, endpoint, token); err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrNotFound
+	default:
+		return "", fmt.Errorf("unexpected status '%s' of registry '%s'", resp.Status, domain)
+	}
+
+	if d := resp.Header.Get(headerContentDigest); d != "" {
+		if _, err := digest.Parse(d); err != nil {
+			return "", err
+		}
+		return d, nil
+	}
+	// the manifest was requested by digest and the registry does not report it
+	if _, err := digest.Parse(target); err == nil {
+		return target, nil
+	}
+	return "", fmt.Errorf("registry '%s' did not return the digest of the manifest", domain)
+}
+
+// token requests an anonymous bearer token for the challenge 'Bearer realm="<url>",service="<service>",scope="<scope>"'
+func (r *RegistryResolver) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("unsupported authentication challenge '%s'", challenge)
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(challenge[len("bearer "):], ",") {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid authentication realm '%s'", params["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	resp, err := r.do(ctx, http.MethodGet, realm.String(), "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status '%s' of authentication realm '%s'", resp.Status, realm.Host)
+	}
+
+	body := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+func (r *RegistryResolver) do(ctx context.Context, method, endpoint, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = defaultClient
+	}
+	return client.Do(req)
+}
+
+func (r *RegistryResolver) cached(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[key]
+	if !ok || !r.now().Before(entry.expires) {
+		return "", false
+	}
+	return entry.digest, true
+}
+
+func (r *RegistryResolver) store(key, d string) {
+	if r.TTL <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cache == nil {
+		r.cache = make(map[string]cacheEntry)
+	}
+	r.cache[key] = cacheEntry{digest: d, expires: r.now().Add(r.TTL)}
+}
+
+func (r *RegistryResolver) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+// IsNotFound returns true if the error is caused by a manifest which does not exist in the registry
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
@@ -0,0 +1,128 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/image"
+)
+
+var _ = Describe("RegistryResolver", func() {
+	var (
+		registry *testRegistry
+		server   *httptest.Server
+		resolver *image.RegistryResolver
+		fake     *clock.FakeClock
+	)
+	BeforeEach(func() {
+		registry = &testRegistry{manifests: map[string]string{"argoproj/argocd:v2.0.5": testDigest, "argoproj/argocd@" + testDigest: testDigest}}
+		server = httptest.NewServer(registry)
+		fake = clock.NewFakeClock(time.Date(2021, time.June, 7, 12, 0, 0, 0, time.UTC))
+		resolver = &image.RegistryResolver{Client: server.Client(), PlainHTTP: []string{testHost(server)}, TTL: time.Minute, Clock: fake}
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	It("should_resolve_tag_to_digest", func() {
+		ref := testResolve(resolver, testHost(server)+"/argoproj/argocd:v2.0.5")
+		Ω(ref.Digest).Should(Equal(testDigest))
+		Ω(ref.Version()).Should(Equal(testDigest))
+		Ω(ref.Repository).Should(Equal(testHost(server) + "/argoproj/argocd"))
+	})
+	It("should_verify_pinned_digest", func() {
+		ref := testResolve(resolver, testHost(server)+"/argoproj/argocd@"+testDigest)
+		Ω(ref.Digest).Should(Equal(testDigest))
+	})
+	It("should_return_not_found_for_unknown_tag", func() {
+		ref, err := image.Parse(testHost(server) + "/argoproj/argocd:v9.9.9")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = resolver.Resolve(context.TODO(), ref)
+		Ω(errors.Is(err, image.ErrNotFound)).Should(BeTrue())
+	})
+	It("should_authenticate_with_anonymous_bearer_token", func() {
+		registry.realm = server.URL + "/token"
+
+		ref := testResolve(resolver, testHost(server)+"/argoproj/argocd:v2.0.5")
+		Ω(ref.Digest).Should(Equal(testDigest))
+		Ω(registry.scopes).Should(ConsistOf("repository:argoproj/argocd:pull"))
+	})
+	It("should_cache_resolved_digests_until_ttl_expires", func() {
+		testResolve(resolver, testHost(server)+"/argoproj/argocd:v2.0.5")
+		testResolve(resolver, testHost(server)+"/argoproj/argocd:v2.0.5")
+		Ω(registry.requests).Should(Equal(1))
+
+		fake.Step(time.Minute)
+		testResolve(resolver, testHost(server)+"/argoproj/argocd:v2.0.5")
+		Ω(registry.requests).Should(Equal(2))
+	})
+})
+
+func testResolve(resolver image.Resolver, value string) image.Reference {
+	ref, err := image.Parse(value)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	ret, err := resolver.Resolve(context.TODO(), ref)
+	Ω(err).ShouldNot(HaveOccurred())
+	return ret
+}
+
+func testHost(server *httptest.Server) string {
+	u, err := url.Parse(server.URL)
+	Ω(err).ShouldNot(HaveOccurred())
+	return u.Host
+}
+
+// testRegistry is a stand-in for a registry which serves the digests of the manifests '<path>:<tag>' and
+// '<path>@<digest>', it requires an anonymous bearer token if the realm is set
+type testRegistry struct {
+	manifests map[string]string
+	realm     string
+	scopes    []string
+	requests  int
+}
+
+func (t *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		t.scopes = append(t.scopes, req.URL.Query().Get("scope"))
+		_, _ = fmt.Fprint(w, `{"token":"anonymous"}`)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	i := strings.LastIndex(path, "/manifests/")
+	if req.Method != http.MethodHead || i < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	repository, target := path[:i], path[i+len("/manifests/"):]
+
+	if t.realm != "" && req.Header.Get("Authorization") != "Bearer anonymous" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry",scope="repository:%s:pull"`, t.realm, repository))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	t.requests++
+
+	separator := ":"
+	if strings.Contains(target, ":") {
+		separator = "@"
+	}
+	d, ok := t.manifests[repository+separator+target]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", d)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,186 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron is a window which opens at the times matching a cron expression and stays open for the duration. Like in
+// the standard cron a day matches either the day of month or the day of week if both are restricted.
+type cron struct {
+	minutes  []int
+	hours    []int
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// anyDay and anyWeekday are set if the day of month or the day of week is not restricted ('*')
+	anyDay     bool
+	anyWeekday bool
+	duration   time.Duration
+	location   *time.Location
+}
+
+// Open implements the Window interface, the window is open if it opened within the duration before the given time
+func (c *cron) Open(t time.Time) bool {
+	start, ok := c.previous(t)
+	return ok && t.Before(start.Add(c.duration))
+}
+
+// Next implements the Window interface
+func (c *cron) Next(t time.Time) (time.Time, bool) {
+	if c.Open(t) {
+		return t, true
+	}
+
+	t = t.In(c.location)
+	year, month, day := t.Date()
+	for i := 0; i <= int(scanLimit/(24*time.Hour)); i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, c.location)
+		if !c.matchesDay(date) {
+			continue
+		}
+		for _, hour := range c.hours {
+			for _, minute := range c.minutes {
+				if next := c.at(date, hour, minute); !next.Before(t) {
+					return next, true
+				}
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// previous returns the latest time at or before the given time at which the window opened within its duration
+func (c *cron) previous(t time.Time) (time.Time, bool) {
+	t = t.In(c.location)
+	year, month, day := t.Date()
+	for i := 0; i <= int(c.duration/(24*time.Hour))+1; i++ {
+		date := time.Date(year, month, day-i, 0, 0, 0, 0, c.location)
+		if !c.matchesDay(date) {
+			continue
+		}
+		for h := len(c.hours) - 1; h >= 0; h-- {
+			for m := len(c.minutes) - 1; m >= 0; m-- {
+				if prev := c.at(date, c.hours[h], c.minutes[m]); !prev.After(t) {
+					return prev, true
+				}
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// matchesDay returns true if the month and the day of month or week of the date match
+func (c *cron) matchesDay(date time.Time) bool {
+	if !c.months[int(date.Month())] {
+		return false
+	}
+	day, weekday := c.days[date.Day()], c.weekdays[int(date.Weekday())]
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+func (c *cron) at(date time.Time, hour, minute int) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, hour, minute, 0, 0, c.location)
+}
+
+func parseCron(fields []string) (Window, error) {
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("expected '<minute> <hour> <day of month> <month> <day of week> <duration>'")
+	}
+
+	c := &cron{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+	minutes, err := parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, err
+	}
+	c.minutes, c.hours = sorted(minutes), sorted(hours)
+	if c.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is an alias of sunday
+	if c.weekdays[7] {
+		c.weekdays[0] = true
+	}
+
+	if c.duration, err = time.ParseDuration(fields[5]); err != nil {
+		return nil, err
+	}
+	if c.duration < time.Minute || c.duration > maxDuration {
+		return nil, fmt.Errorf("duration '%s' must be between 1m and %s", fields[5], maxDuration)
+	}
+
+	if c.location, err = location(fields[6:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges ('a-b') and steps ('*/n' or 'a-b/n')
+func parseCronField(value string, min, max int) (map[int]bool, error) {
+	ret := make(map[int]bool)
+	for _, item := range strings.Split(value, ",") {
+		step := 1
+		if split := strings.Split(item, "/"); len(split) == 2 {
+			var err error
+			if step, err = strconv.Atoi(split[1]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step '%s'", item)
+			}
+			item = split[0]
+		}
+
+		from, to := min, max
+		if item != "*" {
+			split := strings.Split(item, "-")
+			var err error
+			if from, err = strconv.Atoi(split[0]); err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", item)
+			}
+			to = from
+			if len(split) == 2 {
+				if to, err = strconv.Atoi(split[1]); err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", item)
+				}
+			} else if len(split) > 2 {
+				return nil, fmt.Errorf("invalid range '%s'", item)
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("value '%s' out of range %d-%d", item, min, max)
+		}
+
+		for i := from; i <= to; i += step {
+			ret[i] = true
+		}
+	}
+	return ret, nil
+}
+
+// sorted returns the values of a cron field in ascending order
+func sorted(values map[int]bool) []int {
+	var ret []int
+	for value := range values {
+		ret = append(ret, value)
+	}
+	sort.Ints(ret)
+	return ret
+}
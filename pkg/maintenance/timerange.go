@@ -0,0 +1,121 @@
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// timeRange is a window which opens on the given weekdays between start and end (minutes of the day). If end is
+// before start the window ends on the following day.
+type timeRange struct {
+	days     map[time.Weekday]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// Open implements the Window interface
+func (r *timeRange) Open(t time.Time) bool {
+	t = t.In(r.location)
+	minute := t.Hour()*60 + t.Minute()
+
+	if r.start <= r.end {
+		return r.days[t.Weekday()] && minute >= r.start && minute < r.end
+	}
+	// the window wraps around midnight
+	return (r.days[t.Weekday()] && minute >= r.start) || (r.days[(t.Weekday()+6)%7] && minute < r.end)
+}
+
+// Next implements the Window interface, a time range repeats weekly
+func (r *timeRange) Next(t time.Time) (time.Time, bool) {
+	return scan(t, 8*24*time.Hour, r.Open)
+}
+
+func parseTimeRange(fields []string) (Window, error) {
+	r := &timeRange{days: make(map[time.Weekday]bool)}
+
+	// the weekdays are optional
+	if !strings.Contains(fields[0], ":") {
+		if err := parseWeekdays(fields[0], r.days); err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+	} else {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			r.days[day] = true
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing time range")
+	}
+
+	split := strings.Split(fields[0], "-")
+	if len(split) != 2 {
+		return nil, fmt.Errorf("invalid time range '%s'", fields[0])
+	}
+	var err error
+	if r.start, err = parseClock(split[0]); err != nil {
+		return nil, err
+	}
+	if r.end, err = parseClock(split[1]); err != nil {
+		return nil, err
+	}
+	if r.start == r.end {
+		return nil, fmt.Errorf("empty time range '%s'", fields[0])
+	}
+
+	if r.location, err = location(fields[1:]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseWeekdays parses a comma separated list of weekdays or ranges of weekdays, e.g. 'Mon-Fri' or 'Sat,Sun'
+func parseWeekdays(value string, days map[time.Weekday]bool) error {
+	for _, item := range strings.Split(value, ",") {
+		split := strings.Split(item, "-")
+		from, ok := weekdays[strings.ToLower(split[0])]
+		if !ok {
+			return fmt.Errorf("invalid weekday '%s'", split[0])
+		}
+		to := from
+		if len(split) == 2 {
+			if to, ok = weekdays[strings.ToLower(split[1])]; !ok {
+				return fmt.Errorf("invalid weekday '%s'", split[1])
+			}
+		} else if len(split) > 2 {
+			return fmt.Errorf("invalid weekdays '%s'", item)
+		}
+
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM into the minutes of the day, 24:00 is the end of the day
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		if value == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
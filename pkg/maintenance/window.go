@@ -0,0 +1,111 @@
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// scanLimit - maximum period which is scanned for the next opening of a window
+	scanLimit = 366 * 24 * time.Hour
+	// maxDuration - maximum duration of a cron window
+	maxDuration = 7 * 24 * time.Hour
+)
+
+// Window is a recurring maintenance window
+type Window interface {
+	// Open returns true if the window is open at the given time
+	Open(t time.Time) bool
+	// Next returns the time the window opens next (the given time if it is open) and false if it does not open within
+	// a year
+	Next(t time.Time) (time.Time, bool)
+}
+
+// Windows is a list of maintenance windows which is open if any of its windows is open
+type Windows []Window
+
+// Parse parses a semicolon separated list of maintenance windows. Each window is either a weekday/time-range
+// '[<weekdays>] <HH:MM>-<HH:MM> [<timezone>]' (e.g. 'Mon-Fri 22:00-02:00 Europe/Zurich') or a cron expression with a
+// duration 'cron <minute> <hour> <day of month> <month> <day of week> <duration> [<timezone>]' (e.g. 'cron 0 22 * * 6 4h').
+// The timezone is an IANA name and defaults to UTC.
+func Parse(value string) (Windows, error) {
+	var ret Windows
+	for _, s := range strings.Split(value, ";") {
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var window Window
+		var err error
+		if fields[0] == "cron" {
+			window, err = parseCron(fields[1:])
+		} else {
+			window, err = parseTimeRange(fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window '%s': %w", strings.TrimSpace(s), err)
+		}
+		ret = append(ret, window)
+	}
+	return ret, nil
+}
+
+// Open returns true if any window is open at the given time, an empty list of windows is always open
+func (w Windows) Open(t time.Time) bool {
+	if len(w) == 0 {
+		return true
+	}
+	for _, window := range w {
+		if window.Open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Until returns the duration until any window opens, zero if a window is open at the given time and false if no
+// window opens within a year
+func (w Windows) Until(t time.Time) (time.Duration, bool) {
+	if w.Open(t) {
+		return 0, true
+	}
+
+	var next time.Time
+	for _, window := range w {
+		if n, ok := window.Next(t); ok && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return next.Sub(t), true
+}
+
+// scan returns the first minute from the given time on (within the limit) which matches
+func scan(t time.Time, limit time.Duration, matches func(time.Time) bool) (time.Time, bool) {
+	if matches(t) {
+		return t, true
+	}
+	t = t.Truncate(time.Minute)
+	for d := time.Minute; d <= limit; d += time.Minute {
+		if matches(t.Add(d)) {
+			return t.Add(d), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// location parses an optional timezone
+func location(fields []string) (*time.Location, error) {
+	switch len(fields) {
+	case 0:
+		return time.UTC, nil
+	case 1:
+		return time.LoadLocation(fields[0])
+	default:
+		return nil, fmt.Errorf("unexpected fields %v", fields)
+	}
+}
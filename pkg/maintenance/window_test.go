@@ -0,0 +1,135 @@
+package maintenance_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/maintenance"
+)
+
+var _ = Describe("Windows", func() {
+	until := func(windows maintenance.Windows, t time.Time) time.Duration {
+		d, ok := windows.Until(t)
+		Ω(ok).Should(BeTrue())
+		return d
+	}
+
+	// 2021-06-07 is a Monday
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2021, time.June, 7, hour, minute, 0, 0, time.UTC)
+	}
+
+	Context("Parse", func() {
+		It("should_parse_time_ranges_and_cron_expressions", func() {
+			windows, err := maintenance.Parse("Mon-Fri 22:00-02:00 Europe/Zurich; cron */15 2 1 * * 30m")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(windows).Should(HaveLen(2))
+		})
+		It("should_return_no_windows_if_empty", func() {
+			windows, err := maintenance.Parse(" ")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(windows).Should(BeEmpty())
+			Ω(windows.Open(monday(12, 0))).Should(BeTrue())
+		})
+		DescribeTable("should_reject_invalid_windows",
+			func(value string) {
+				_, err := maintenance.Parse(value)
+				Ω(err).Should(HaveOccurred())
+			},
+			Entry("weekday", "Moo 22:00-23:00"),
+			Entry("time", "25:00-26:00"),
+			Entry("empty range", "10:00-10:00"),
+			Entry("timezone", "10:00-11:00 Mars/Olympus"),
+			Entry("cron fields", "cron 0 22 * *"),
+			Entry("cron value", "cron 60 22 * * * 1h"),
+			Entry("cron duration", "cron 0 22 * * * 8d"),
+		)
+	})
+	Context("TimeRange", func() {
+		It("should_open_on_weekdays_within_range", func() {
+			windows, err := maintenance.Parse("Mon-Fri 08:00-10:00")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(windows.Open(monday(7, 59))).Should(BeFalse())
+			Ω(windows.Open(monday(8, 0))).Should(BeTrue())
+			Ω(windows.Open(monday(10, 0))).Should(BeFalse())
+			// Sunday
+			Ω(windows.Open(monday(9, 0).AddDate(0, 0, -1))).Should(BeFalse())
+		})
+		It("should_wrap_around_midnight", func() {
+			windows, err := maintenance.Parse("Sun 22:00-02:00")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(windows.Open(monday(1, 0))).Should(BeTrue())
+			Ω(windows.Open(monday(23, 0))).Should(BeFalse())
+		})
+		It("should_respect_timezone", func() {
+			windows, err := maintenance.Parse("08:00-09:00 Europe/Zurich")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			// CEST is UTC+2 in June
+			Ω(windows.Open(monday(6, 30))).Should(BeTrue())
+			Ω(windows.Open(monday(8, 30))).Should(BeFalse())
+		})
+		It("should_return_duration_until_window_opens", func() {
+			windows, err := maintenance.Parse("Mon-Fri 22:00-23:00")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(until(windows, monday(21, 30))).Should(Equal(30 * time.Minute))
+			Ω(until(windows, monday(22, 30))).Should(BeZero())
+			// Friday after the window opens again on Monday
+			Ω(until(windows, monday(23, 0).AddDate(0, 0, 4))).Should(Equal(71 * time.Hour))
+		})
+	})
+	Context("Cron", func() {
+		It("should_stay_open_for_duration", func() {
+			windows, err := maintenance.Parse("cron 0 22 * * 1 2h")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(windows.Open(monday(21, 59))).Should(BeFalse())
+			Ω(windows.Open(monday(22, 0))).Should(BeTrue())
+			Ω(windows.Open(monday(23, 59))).Should(BeTrue())
+			Ω(windows.Open(monday(22, 0).Add(2 * time.Hour))).Should(BeFalse())
+		})
+		It("should_return_duration_until_window_opens", func() {
+			windows, err := maintenance.Parse("cron 0 22 * * 1 2h; Tue 00:00-24:00")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Ω(until(windows, monday(20, 0))).Should(Equal(2 * time.Hour))
+			Ω(until(windows, monday(23, 0))).Should(BeZero())
+			// the time range on Tuesday follows the cron window
+			Ω(until(windows, monday(23, 59).Add(time.Minute))).Should(BeZero())
+		})
+		It("should_match_day_of_month_or_day_of_week", func() {
+			windows, err := maintenance.Parse("cron 0 2 1 * 1 1h")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			// Monday 2021-06-07 matches the day of week
+			Ω(windows.Open(monday(2, 30))).Should(BeTrue())
+			// Thursday 2021-07-01 matches the day of month
+			Ω(windows.Open(time.Date(2021, time.July, 1, 2, 0, 0, 0, time.UTC))).Should(BeTrue())
+			// Tuesday 2021-06-08 matches neither
+			Ω(windows.Open(monday(2, 0).AddDate(0, 0, 1))).Should(BeFalse())
+			Ω(until(windows, monday(3, 0))).Should(Equal(7*24*time.Hour - time.Hour))
+		})
+		It("should_match_day_of_month_and_day_of_week_if_either_is_unrestricted", func() {
+			windows, err := maintenance.Parse("cron 0 2 */2 * 1 1h")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			// Monday 2021-06-07 is an odd day
+			Ω(windows.Open(monday(2, 0))).Should(BeTrue())
+			// Monday 2021-06-14 is an even day, the next odd Monday is 2021-06-21
+			Ω(until(windows, monday(3, 0))).Should(Equal(14*24*time.Hour - time.Hour))
+		})
+		It("should_not_open_if_no_day_matches_within_a_year", func() {
+			windows, err := maintenance.Parse("cron 0 2 29 2 * 1h")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, ok := windows.Until(monday(0, 0))
+			Ω(ok).Should(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,65 @@
+package mapper
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// EventHandler enqueues the ArgoCD instances affected by namespace events. Other than the
+// handler.EnqueueRequestsFromMapFunc it compares the labels on updates in order to enqueue the previous ArgoCD
+// instance of a relabelled or unlabelled namespace even if it did not record the namespace (yet).
+//
+// If a Window is set the requests are delayed by it and all the events for the same ArgoCD instance within the window
+// are coalesced into a single request, e.g. when many namespaces are labelled at once.
+type EventHandler struct {
+	Mapper handler.Mapper
+	Window time.Duration
+}
+
+// Create implements the handler.EventHandler interface
+func (e *EventHandler) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(q, e.Mapper.Map(handler.MapObject{Meta: evt.Meta, Object: evt.Object}))
+}
+
+// Update implements the handler.EventHandler interface
+func (e *EventHandler) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	requests := e.Mapper.Map(handler.MapObject{Meta: evt.MetaNew, Object: evt.ObjectNew})
+
+	previous, ok := ArgoCDFromLabels(evt.MetaOld.GetLabels())
+	if current, _ := ArgoCDFromLabels(evt.MetaNew.GetLabels()); ok && previous != current {
+		requests = append(requests, reconcile.Request{NamespacedName: previous})
+	}
+
+	e.enqueue(q, requests)
+}
+
+// Delete implements the handler.EventHandler interface
+func (e *EventHandler) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(q, e.Mapper.Map(handler.MapObject{Meta: evt.Meta, Object: evt.Object}))
+}
+
+// Generic implements the handler.EventHandler interface
+func (e *EventHandler) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	e.enqueue(q, e.Mapper.Map(handler.MapObject{Meta: evt.Meta, Object: evt.Object}))
+}
+
+// enqueue adds the requests to the queue, each ArgoCD instance only once. The delaying queue keeps the earliest
+// ready time of an item which is waiting, hence the requests of all the events within the window are coalesced.
+func (e *EventHandler) enqueue(q workqueue.RateLimitingInterface, requests []reconcile.Request) {
+	added := make(map[types.NamespacedName]bool)
+	for _, request := range requests {
+		if !added[request.NamespacedName] {
+			added[request.NamespacedName] = true
+			if e.Window > 0 {
+				q.AddAfter(request, e.Window)
+			} else {
+				q.Add(request)
+			}
+		}
+	}
+}
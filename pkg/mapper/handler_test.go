@@ -0,0 +1,135 @@
+package mapper_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/mapper"
+)
+
+var _ = Describe("EventHandler", func() {
+	var (
+		queue workqueue.RateLimitingInterface
+	)
+	BeforeEach(func() {
+		queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	})
+	AfterEach(func() {
+		queue.ShutDown()
+	})
+	It("should_enqueue_previous_and_new_argocd_on_relabel", func() {
+		old := testNamespace("mynamespace", "argocd-a")
+		current := testNamespace("mynamespace", "argocd-b")
+
+		h := &mapper.EventHandler{Mapper: testMapper()}
+		h.Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: current, ObjectNew: current}, queue)
+
+		Ω(drain(queue)).Should(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-a", Namespace: "default"}},
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-b", Namespace: "default"}},
+		))
+	})
+	It("should_enqueue_previous_argocd_on_unlabel", func() {
+		old := testNamespace("mynamespace", "argocd-a")
+		current := testNamespace("mynamespace", "")
+
+		h := &mapper.EventHandler{Mapper: testMapper()}
+		h.Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: current, ObjectNew: current}, queue)
+
+		Ω(drain(queue)).Should(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-a", Namespace: "default"}},
+		))
+	})
+	It("should_enqueue_argocd_only_once_if_labels_did_not_change", func() {
+		argocd := &argoprojv1alpha1.ArgoCD{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "argocd-a",
+				Namespace: "default",
+				Annotations: map[string]string{
+					constants.AnnotationNamespaces: "default,mynamespace",
+				},
+			},
+		}
+		old := testNamespace("mynamespace", "argocd-a")
+		current := testNamespace("mynamespace", "argocd-a")
+
+		h := &mapper.EventHandler{Mapper: testMapper(argocd)}
+		h.Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: current, ObjectNew: current}, queue)
+
+		Ω(drain(queue)).Should(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-a", Namespace: "default"}},
+		))
+	})
+	It("should_enqueue_labelled_and_recording_argocd_on_delete", func() {
+		argocd := &argoprojv1alpha1.ArgoCD{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "argocd-b",
+				Namespace: "default",
+				Annotations: map[string]string{
+					constants.AnnotationNamespaces: "default,mynamespace",
+				},
+			},
+		}
+		namespace := testNamespace("mynamespace", "argocd-a")
+
+		h := &mapper.EventHandler{Mapper: testMapper(argocd)}
+		h.Delete(event.DeleteEvent{Meta: namespace, Object: namespace}, queue)
+
+		Ω(drain(queue)).Should(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-a", Namespace: "default"}},
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-b", Namespace: "default"}},
+		))
+	})
+	It("should_coalesce_events_within_window", func() {
+		h := &mapper.EventHandler{Mapper: testMapper(), Window: 100 * time.Millisecond}
+		for _, name := range []string{"myapp1", "myapp2", "myapp3"} {
+			namespace := testNamespace(name, "argocd-a")
+			h.Create(event.CreateEvent{Meta: namespace, Object: namespace}, queue)
+		}
+
+		Ω(queue.Len()).Should(BeZero())
+		Eventually(queue.Len).Should(Equal(1))
+		Consistently(queue.Len, 200*time.Millisecond).Should(Equal(1))
+		Ω(drain(queue)).Should(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "argocd-a", Namespace: "default"}},
+		))
+	})
+})
+
+// testNamespace creates a namespace which is labelled with the ArgoCD instance 'default/<argocd>' if not empty
+func testNamespace(name, argocd string) *corev1.Namespace {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if argocd != "" {
+		namespace.Labels = map[string]string{
+			constants.LabelArgoCDName:      argocd,
+			constants.LabelArgoCDNamespace: "default",
+		}
+	}
+	return namespace
+}
+
+// drain returns all the requests currently in the queue
+func drain(queue workqueue.RateLimitingInterface) []reconcile.Request {
+	var ret []reconcile.Request
+	for queue.Len() > 0 {
+		item, _ := queue.Get()
+		ret = append(ret, item.(reconcile.Request))
+		queue.Done(item)
+	}
+	return ret
+}
@@ -0,0 +1,99 @@
+package mapper
+
+import (
+	"context"
+	"strings"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+)
+
+const (
+	// IndexNamespaceArgoCD - field index of the namespaces by the namespaced name of the ArgoCD instance in their labels
+	IndexNamespaceArgoCD = "metadata.labels.argocd"
+	// IndexArgoCDNamespaces - field index of the ArgoCD instances by the namespaces recorded in their annotation
+	IndexArgoCDNamespaces = "metadata.annotations.namespaces"
+)
+
+// IndexFields registers the field indexes used to map namespaces to ArgoCD instances and vice versa
+func IndexFields(ctx context.Context, indexer client.FieldIndexer) error {
+	if err := indexer.IndexField(ctx, &corev1.Namespace{}, IndexNamespaceArgoCD, func(obj runtime.Object) []string {
+		if namespace, ok := obj.(*corev1.Namespace); ok {
+			if argocd, ok := ArgoCDFromLabels(namespace.Labels); ok {
+				return []string{argocd.String()}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return indexer.IndexField(ctx, &argoprojv1alpha1.ArgoCD{}, IndexArgoCDNamespaces, func(obj runtime.Object) []string {
+		if argocd, ok := obj.(*argoprojv1alpha1.ArgoCD); ok {
+			return RecordedNamespaces(argocd)
+		}
+		return nil
+	})
+}
+
+// ArgoCDFromLabels returns the namespaced name of the ArgoCD instance a namespace is bound to by its labels
+func ArgoCDFromLabels(labels map[string]string) (types.NamespacedName, bool) {
+	if labels[constants.LabelArgoCDName] == "" || labels[constants.LabelArgoCDNamespace] == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: labels[constants.LabelArgoCDNamespace], Name: labels[constants.LabelArgoCDName]}, true
+}
+
+// RecordedNamespaces returns the namespaces recorded in the annotation of an ArgoCD instance
+func RecordedNamespaces(obj metav1.Object) []string {
+	var ret []string
+	for _, namespace := range strings.Split(obj.GetAnnotations()[constants.AnnotationNamespaces], ",") {
+		if namespace != "" {
+			ret = append(ret, namespace)
+		}
+	}
+	return ret
+}
+
+// ListBoundNamespaces lists the namespaces which are bound to an ArgoCD instance by their labels
+func ListBoundNamespaces(ctx context.Context, reader client.Reader, argocd types.NamespacedName) ([]corev1.Namespace, error) {
+	list := corev1.NamespaceList{}
+	if err := reader.List(ctx, &list, client.MatchingFields{IndexNamespaceArgoCD: argocd.String()}); err != nil {
+		return nil, err
+	}
+
+	// verify the labels in case the reader does not support field indexes
+	var ret []corev1.Namespace
+	for _, namespace := range list.Items {
+		if nn, ok := ArgoCDFromLabels(namespace.Labels); ok && nn == argocd {
+			ret = append(ret, namespace)
+		}
+	}
+	return ret, nil
+}
+
+// ListRecordingArgoCDs lists the ArgoCD instances which recorded the given namespace as bound
+func ListRecordingArgoCDs(ctx context.Context, reader client.Reader, namespace string) ([]argoprojv1alpha1.ArgoCD, error) {
+	list := argoprojv1alpha1.ArgoCDList{}
+	if err := reader.List(ctx, &list, client.MatchingFields{IndexArgoCDNamespaces: namespace}); err != nil {
+		return nil, err
+	}
+
+	// verify the annotation in case the reader does not support field indexes
+	var ret []argoprojv1alpha1.ArgoCD
+	for _, argocd := range list.Items {
+		for _, recorded := range RecordedNamespaces(&argocd) {
+			if recorded == namespace {
+				ret = append(ret, argocd)
+				break
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,103 @@
+package mapper_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/mapper"
+)
+
+var _ = Describe("Index", func() {
+	Context("IndexFields", func() {
+		It("should_index_namespace_labels_and_recorded_namespaces", func() {
+			indexer := fieldIndexer{}
+			Ω(mapper.IndexFields(context.TODO(), indexer)).ShouldNot(HaveOccurred())
+
+			Ω(indexer[mapper.IndexNamespaceArgoCD](&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "mynamespace",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      "argocd",
+						constants.LabelArgoCDNamespace: "default",
+					},
+				},
+			})).Should(ConsistOf("default/argocd"))
+			Ω(indexer[mapper.IndexNamespaceArgoCD](&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "mynamespace"},
+			})).Should(BeEmpty())
+
+			Ω(indexer[mapper.IndexArgoCDNamespaces](&argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNamespaces: "default,mynamespace",
+					},
+				},
+			})).Should(ConsistOf("default", "mynamespace"))
+		})
+	})
+	Context("ArgoCDFromLabels", func() {
+		It("should_require_name_and_namespace", func() {
+			_, ok := mapper.ArgoCDFromLabels(map[string]string{constants.LabelArgoCDName: "argocd"})
+			Ω(ok).Should(BeFalse())
+
+			nn, ok := mapper.ArgoCDFromLabels(map[string]string{constants.LabelArgoCDName: "argocd", constants.LabelArgoCDNamespace: "default"})
+			Ω(ok).Should(BeTrue())
+			Ω(nn).Should(Equal(types.NamespacedName{Name: "argocd", Namespace: "default"}))
+		})
+	})
+	Context("RecordedNamespaces", func() {
+		It("should_ignore_missing_annotation", func() {
+			Ω(mapper.RecordedNamespaces(&argoprojv1alpha1.ArgoCD{})).Should(BeEmpty())
+		})
+	})
+	Context("ListBoundNamespaces", func() {
+		It("should_only_list_labelled_namespaces", func() {
+			s := scheme.Scheme
+			Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+			cl := client.NewFakeClientWithScheme(s,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "myapp1"}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name: "myapp2",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      "argocd",
+						constants.LabelArgoCDNamespace: "default",
+					},
+				}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name: "myapp3",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      "other",
+						constants.LabelArgoCDNamespace: "default",
+					},
+				}},
+			)
+
+			namespaces, err := mapper.ListBoundNamespaces(context.TODO(), cl, types.NamespacedName{Name: "argocd", Namespace: "default"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(namespaces).Should(HaveLen(1))
+			Ω(namespaces[0].Name).Should(Equal("myapp2"))
+		})
+	})
+})
+
+// fieldIndexer implements the client.FieldIndexer interface and keeps the registered functions by field
+type fieldIndexer map[string]ctrlclient.IndexerFunc
+
+func (i fieldIndexer) IndexField(_ context.Context, _ runtime.Object, field string, extractValue ctrlclient.IndexerFunc) error {
+	i[field] = extractValue
+	return nil
+}
@@ -1,72 +1,66 @@
 package mapper
 
 import (
-	"github.com/snorwin/argocd-operator-extension/pkg/constants"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-// Mapper maps namespaces to their ArgoCD instance
+// Mapper maps namespaces to their ArgoCD instances by looking up the field indexes of the cache
 type Mapper struct {
-	Graph DependencyGraph
+	client.Reader
+	Log logr.Logger
 }
 
-// Map implements the handler.Mapper interface
+// Map implements the handler.Mapper interface and returns the ArgoCD instance the namespace is labelled with as well
+// as the ArgoCD instances which recorded the namespace as bound
 func (m *Mapper) Map(obj handler.MapObject) []reconcile.Request {
-	var ret []reconcile.Request
+	requests := make(map[types.NamespacedName]bool)
 
-	ref := ReferenceFromMapObject(obj)
-
-	labels := obj.Meta.GetLabels()
-	if labels[constants.LabelArgoCDName] != "" && labels[constants.LabelArgoCDNamespace] != "" {
-		m.Graph.AddDependency(ref, Reference{
-			APIGroup:  "argoproj.io",
-			Kind:      "ArgoCD",
-			Namespace: labels[constants.LabelArgoCDNamespace],
-			Name:      labels[constants.LabelArgoCDName],
-		})
+	if argocd, ok := ArgoCDFromLabels(obj.Meta.GetLabels()); ok {
+		requests[argocd] = true
 	}
 
-	for _, dependency := range m.Graph.GetAllDependenciesFor(ref) {
-		ret = append(ret, reconcile.Request{
-			NamespacedName: types.NamespacedName{
-				Namespace: dependency.Namespace,
-				Name:      dependency.Name,
-			},
-		})
+	argocds, err := ListRecordingArgoCDs(context.Background(), m, obj.Meta.GetName())
+	if err != nil {
+		m.Log.Error(err, "unable to list ArgoCD instances", "namespace", obj.Meta.GetName())
+	}
+	for _, argocd := range argocds {
+		requests[types.NamespacedName{Namespace: argocd.Namespace, Name: argocd.Name}] = true
 	}
 
-	return ret
+	return toRequests(requests)
 }
 
-// Reference refers to a kubernetes resource
-type Reference struct {
-	APIGroup  string
-	Kind      string
-	Namespace string
-	Name      string
-}
+// MapNamespaced maps a namespaced object (e.g. a RoleBinding) to the ArgoCD instances which recorded its namespace as
+// bound
+func (m *Mapper) MapNamespaced(obj handler.MapObject) []reconcile.Request {
+	requests := make(map[types.NamespacedName]bool)
 
-// Object unifies the metav1.Object and runtime.Object interface
-type Object interface {
-	metav1.Object
-	runtime.Object
-}
-
-// ReferenceFromMapObject creates a Reference from a handler.MapObject
-func ReferenceFromMapObject(obj handler.MapObject) Reference {
-	return Reference{
-		APIGroup:  obj.Object.GetObjectKind().GroupVersionKind().Group,
-		Kind:      obj.Object.GetObjectKind().GroupVersionKind().Kind,
-		Namespace: obj.Meta.GetNamespace(),
-		Name:      obj.Meta.GetName(),
+	argocds, err := ListRecordingArgoCDs(context.Background(), m, obj.Meta.GetNamespace())
+	if err != nil {
+		m.Log.Error(err, "unable to list ArgoCD instances", "namespace", obj.Meta.GetNamespace())
+	}
+	for _, argocd := range argocds {
+		requests[types.NamespacedName{Namespace: argocd.Namespace, Name: argocd.Name}] = true
 	}
+
+	return toRequests(requests)
 }
 
-// ReferenceFromObject creates a Reference from an Object
-func ReferenceFromObject(obj Object) Reference {
-	return ReferenceFromMapObject(handler.MapObject{Meta: obj, Object: obj})
+// toRequests converts a set of namespaced names to a sorted slice of reconcile.Request
+func toRequests(set map[types.NamespacedName]bool) []reconcile.Request {
+	var ret []reconcile.Request
+	for nn := range set {
+		ret = append(ret, reconcile.Request{NamespacedName: nn})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].NamespacedName.String() < ret[j].NamespacedName.String()
+	})
+	return ret
 }
@@ -5,10 +5,15 @@ import (
 	. "github.com/onsi/gomega"
 
 	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	logr "github.com/go-logr/logr/testing"
 	"github.com/snorwin/argocd-operator-extension/pkg/constants"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
@@ -16,168 +21,142 @@ import (
 )
 
 var _ = Describe("Mapper", func() {
-	var (
-		m *mapper.Mapper
-	)
 	Context("Map", func() {
-		BeforeEach(func() {
-			m = &mapper.Mapper{}
-		})
-		It("pod_with_two_configmaps_as_dependency", func() {
-			pod := &corev1.Pod{
-				ObjectMeta: metav1.ObjectMeta{Name: "mypod", Namespace: "default"},
-			}
-			cm1 := &corev1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{Name: "cm1", Namespace: "default"},
-			}
-			cm2 := &corev1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{Name: "cm2", Namespace: "default"},
+		It("namespace_labelled_with_argocd", func() {
+			namespace := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "mynamespace",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      "argocd",
+						constants.LabelArgoCDNamespace: "default",
+					},
+				},
 			}
 
-			m.Graph.AddDependency(mapper.ReferenceFromObject(pod), mapper.ReferenceFromObject(cm1))
-			m.Graph.AddDependency(mapper.ReferenceFromObject(pod), mapper.ReferenceFromObject(cm2))
+			m := testMapper()
 
-			Ω(m.Map(handler.MapObject{Meta: pod, Object: pod})).
+			Ω(m.Map(handler.MapObject{Meta: namespace, Object: namespace})).
 				Should(ConsistOf([]reconcile.Request{
-					{NamespacedName: types.NamespacedName{Name: cm1.Name, Namespace: cm1.Namespace}},
-					{NamespacedName: types.NamespacedName{Name: cm2.Name, Namespace: cm2.Namespace}},
+					{NamespacedName: types.NamespacedName{Name: "argocd", Namespace: "default"}},
 				}))
 		})
-		It("namespace_add_missing_dependency", func() {
+		It("namespace_recorded_by_argocd", func() {
 			argocd := &argoprojv1alpha1.ArgoCD{
-				TypeMeta: metav1.TypeMeta{
-					APIVersion: "argoproj.io/v1alpha1",
-					Kind:       "ArgoCD",
-				},
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "argocd",
 					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNamespaces: "default,mynamespace",
+					},
 				},
 			}
 
 			namespace := &corev1.Namespace{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "mynamespace",
-					Labels: map[string]string{
-						constants.LabelArgoCDName:      argocd.Name,
-						constants.LabelArgoCDNamespace: argocd.Namespace,
-					},
 				},
 			}
 
+			m := testMapper(argocd)
+
 			Ω(m.Map(handler.MapObject{Meta: namespace, Object: namespace})).
 				Should(ConsistOf([]reconcile.Request{
 					{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}},
 				}))
-
-			Ω(m.Graph.HasDependency(mapper.ReferenceFromObject(namespace), mapper.ReferenceFromObject(argocd))).Should(BeTrue())
-			Ω(m.Graph.HasDependency(mapper.ReferenceFromObject(argocd), mapper.ReferenceFromObject(namespace))).Should(BeTrue())
 		})
-		It("namespace_with_dependency", func() {
+		It("namespace_labelled_and_recorded_by_different_argocds", func() {
 			argocd := &argoprojv1alpha1.ArgoCD{
-				TypeMeta: metav1.TypeMeta{
-					APIVersion: "argoproj.io/v1alpha1",
-					Kind:       "ArgoCD",
-				},
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "argocd",
 					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNamespaces: "default,mynamespace",
+					},
 				},
 			}
 
 			namespace := &corev1.Namespace{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: "mynamespace",
+					Labels: map[string]string{
+						constants.LabelArgoCDName:      "other",
+						constants.LabelArgoCDNamespace: "default",
+					},
 				},
 			}
 
-			m.Graph.AddDependency(mapper.ReferenceFromObject(argocd), mapper.ReferenceFromObject(namespace))
+			m := testMapper(argocd)
 
 			Ω(m.Map(handler.MapObject{Meta: namespace, Object: namespace})).
-				Should(ConsistOf([]reconcile.Request{
-					{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}},
+				Should(Equal([]reconcile.Request{
+					{NamespacedName: types.NamespacedName{Name: "argocd", Namespace: "default"}},
+					{NamespacedName: types.NamespacedName{Name: "other", Namespace: "default"}},
 				}))
 		})
-	})
-})
-
-var _ = Describe("Helper", func() {
-	Context("ReferenceFromObject", func() {
-		It("pod", func() {
-			obj := &corev1.Pod{
-				ObjectMeta: metav1.ObjectMeta{Name: "mypod", Namespace: "default"},
+		It("namespace_without_argocd", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "argocd",
+					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNamespaces: "default,othernamespace",
+					},
+				},
 			}
 
-			ref := mapper.ReferenceFromObject(obj)
-
-			Ω(ref.APIGroup).Should(Equal(obj.GroupVersionKind().Group))
-			Ω(ref.Kind).Should(Equal(obj.GroupVersionKind().Kind))
-			Ω(ref.Namespace).Should(Equal(obj.Namespace))
-			Ω(ref.Name).Should(Equal(obj.Name))
-		})
-		It("namespace", func() {
-			obj := &corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{Name: "mynamespace"},
+			namespace := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "mynamespace",
+				},
 			}
 
-			ref := mapper.ReferenceFromObject(obj)
+			m := testMapper(argocd)
 
-			Ω(ref.APIGroup).Should(Equal(obj.GroupVersionKind().Group))
-			Ω(ref.Kind).Should(Equal(obj.GroupVersionKind().Kind))
-			Ω(ref.Namespace).Should(Equal(obj.Namespace))
-			Ω(ref.Name).Should(Equal(obj.Name))
+			Ω(m.Map(handler.MapObject{Meta: namespace, Object: namespace})).Should(BeEmpty())
 		})
-		It("argocd", func() {
-			obj := &argoprojv1alpha1.ArgoCD{
-				TypeMeta: metav1.TypeMeta{
-					APIVersion: "argoproj.io/v1alpha1",
-					Kind:       "ArgoCD",
-				},
+	})
+	Context("MapNamespaced", func() {
+		It("object_in_namespace_recorded_by_argocd", func() {
+			argocd := &argoprojv1alpha1.ArgoCD{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "argocd",
 					Namespace: "default",
+					Annotations: map[string]string{
+						constants.AnnotationNamespaces: "default,mynamespace",
+					},
 				},
 			}
-			ref := mapper.ReferenceFromObject(obj)
-
-			Ω(ref.APIGroup).Should(Equal(obj.GroupVersionKind().Group))
-			Ω(ref.Kind).Should(Equal(obj.GroupVersionKind().Kind))
-			Ω(ref.Namespace).Should(Equal(obj.Namespace))
-			Ω(ref.Name).Should(Equal(obj.Name))
-		})
-	})
-	Context("ReferenceFromMapObject", func() {
-		It("pod", func() {
-			obj := &corev1.Pod{
-				ObjectMeta: metav1.ObjectMeta{Name: "mypod", Namespace: "default"},
-			}
 
-			mapObj := handler.MapObject{
-				Meta:   obj,
-				Object: obj,
+			binding := &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "developers",
+					Namespace: "mynamespace",
+				},
 			}
-			ref := mapper.ReferenceFromMapObject(mapObj)
-
-			Ω(ref.APIGroup).Should(Equal(obj.GroupVersionKind().Group))
-			Ω(ref.Kind).Should(Equal(obj.GroupVersionKind().Kind))
-			Ω(ref.Namespace).Should(Equal(obj.Namespace))
-			Ω(ref.Name).Should(Equal(obj.Name))
-		})
-		It("namespace", func() {
-			obj := &corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{Name: "mynamespace"},
+			other := &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "developers",
+					Namespace: "othernamespace",
+				},
 			}
 
-			mapObj := handler.MapObject{
-				Meta:   obj,
-				Object: obj,
-			}
-			ref := mapper.ReferenceFromMapObject(mapObj)
+			m := testMapper(argocd)
 
-			Ω(ref.APIGroup).Should(Equal(obj.GroupVersionKind().Group))
-			Ω(ref.Kind).Should(Equal(obj.GroupVersionKind().Kind))
-			Ω(ref.Namespace).Should(Equal(obj.Namespace))
-			Ω(ref.Name).Should(Equal(obj.Name))
+			Ω(m.MapNamespaced(handler.MapObject{Meta: binding, Object: binding})).
+				Should(ConsistOf([]reconcile.Request{
+					{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}},
+				}))
+			Ω(m.MapNamespaced(handler.MapObject{Meta: other, Object: other})).Should(BeEmpty())
 		})
 	})
 })
+
+func testMapper(objects ...runtime.Object) *mapper.Mapper {
+	s := scheme.Scheme
+	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+	return &mapper.Mapper{
+		Reader: client.NewFakeClientWithScheme(s, objects...),
+		Log:    logr.NullLogger{},
+	}
+}
@@ -0,0 +1,22 @@
+package mapper
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// BindingLabelsChanged returns a predicate which ignores namespace updates that do not change the labels binding the
+// namespace to an ArgoCD instance. Create, delete and generic events are not filtered.
+func BindingLabelsChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(evt event.UpdateEvent) bool {
+			if evt.MetaOld == nil || evt.MetaNew == nil {
+				return true
+			}
+
+			previous, previousOk := ArgoCDFromLabels(evt.MetaOld.GetLabels())
+			current, currentOk := ArgoCDFromLabels(evt.MetaNew.GetLabels())
+			return previousOk != currentOk || previous != current
+		},
+	}
+}
@@ -0,0 +1,35 @@
+package mapper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/mapper"
+)
+
+var _ = Describe("BindingLabelsChanged", func() {
+	It("should_ignore_updates_of_other_labels", func() {
+		old := testNamespace("mynamespace", "argocd-a")
+		current := testNamespace("mynamespace", "argocd-a")
+		current.Labels["team"] = "myteam"
+
+		Ω(mapper.BindingLabelsChanged().Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: current, ObjectNew: current})).Should(BeFalse())
+	})
+	It("should_accept_relabel_and_unlabel", func() {
+		old := testNamespace("mynamespace", "argocd-a")
+
+		relabelled := testNamespace("mynamespace", "argocd-b")
+		Ω(mapper.BindingLabelsChanged().Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: relabelled, ObjectNew: relabelled})).Should(BeTrue())
+
+		unlabelled := testNamespace("mynamespace", "")
+		Ω(mapper.BindingLabelsChanged().Update(event.UpdateEvent{MetaOld: old, ObjectOld: old, MetaNew: unlabelled, ObjectNew: unlabelled})).Should(BeTrue())
+	})
+	It("should_accept_create_and_delete", func() {
+		namespace := testNamespace("mynamespace", "")
+
+		Ω(mapper.BindingLabelsChanged().Create(event.CreateEvent{Meta: namespace, Object: namespace})).Should(BeTrue())
+		Ω(mapper.BindingLabelsChanged().Delete(event.DeleteEvent{Meta: namespace, Object: namespace})).Should(BeTrue())
+	})
+})
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// InstrumentHelmClientFactory wraps a helm.ClientFactory that the created clients record the count and latency of the
+// install, upgrade and uninstall operations
+func InstrumentHelmClientFactory(factory helm.ClientFactory) helm.ClientFactory {
+	return func(namespace string, options ...helm.ClientOption) (helm.Client, error) {
+		client, err := factory(namespace, options...)
+		if err != nil {
+			return nil, err
+		}
+		return &instrumentedHelmClient{Client: client}, nil
+	}
+}
+
+// instrumentedHelmClient implements the helm.Client interface
+type instrumentedHelmClient struct {
+	helm.Client
+}
+
+// Install records the metrics of the helm.Client Install operation
+func (c *instrumentedHelmClient) Install(release string, chart *chart.Chart, values chartutil.Values) (err error) {
+	defer observeHelmOperation("install", time.Now(), &err)
+	return c.Client.Install(release, chart, values)
+}
+
+// Upgrade records the metrics of the helm.Client Upgrade operation
+func (c *instrumentedHelmClient) Upgrade(release string, chart *chart.Chart, values chartutil.Values, install bool) (err error) {
+	defer observeHelmOperation("upgrade", time.Now(), &err)
+	return c.Client.Upgrade(release, chart, values, install)
+}
+
+// Uninstall records the metrics of the helm.Client Uninstall operation
+func (c *instrumentedHelmClient) Uninstall(release string) (err error) {
+	defer observeHelmOperation("uninstall", time.Now(), &err)
+	return c.Client.Uninstall(release)
+}
+
+func observeHelmOperation(operation string, start time.Time, err *error) {
+	result := ResultSuccess
+	if *err != nil {
+		result = ResultError
+	}
+
+	HelmOperations.WithLabelValues(operation, result).Inc()
+	HelmOperationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
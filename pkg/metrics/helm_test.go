@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snorwin/argocd-operator-extension/pkg/helm"
+	mock_helm "github.com/snorwin/argocd-operator-extension/pkg/mocks/helm"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/metrics"
+)
+
+var _ = Describe("Helm", func() {
+	Context("InstrumentHelmClientFactory", func() {
+		var (
+			mockCtrl *gomock.Controller
+			mockHelm *mock_helm.MockClient
+			client   helm.Client
+		)
+		BeforeEach(func() {
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockHelm = mock_helm.NewMockClient(mockCtrl)
+
+			var err error
+			client, err = metrics.InstrumentHelmClientFactory(func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
+				return mockHelm, nil
+			})("default")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+		It("should_count_successful_upgrades", func() {
+			before := testutil.ToFloat64(metrics.HelmOperations.WithLabelValues("upgrade", metrics.ResultSuccess))
+
+			mockHelm.
+				EXPECT().
+				Upgrade("argocd", gomock.Any(), gomock.Any(), true).
+				Return(nil)
+
+			Ω(client.Upgrade("argocd", nil, nil, true)).ShouldNot(HaveOccurred())
+			Ω(testutil.ToFloat64(metrics.HelmOperations.WithLabelValues("upgrade", metrics.ResultSuccess))).Should(Equal(before + 1))
+		})
+		It("should_count_failed_uninstalls", func() {
+			before := testutil.ToFloat64(metrics.HelmOperations.WithLabelValues("uninstall", metrics.ResultError))
+
+			mockHelm.
+				EXPECT().
+				Uninstall("argocd").
+				Return(errors.New("uninstall failed"))
+
+			Ω(client.Uninstall("argocd")).Should(HaveOccurred())
+			Ω(testutil.ToFloat64(metrics.HelmOperations.WithLabelValues("uninstall", metrics.ResultError))).Should(Equal(before + 1))
+		})
+		It("should_observe_install_latency", func() {
+			mockHelm.
+				EXPECT().
+				Install("argocd", gomock.Any(), gomock.Any()).
+				Return(nil)
+
+			Ω(client.Install("argocd", nil, nil)).ShouldNot(HaveOccurred())
+			Ω(testutil.CollectAndCount(metrics.HelmOperationDuration)).Should(BeNumerically(">=", 1))
+		})
+		It("should_return_factory_errors", func() {
+			_, err := metrics.InstrumentHelmClientFactory(func(_ string, _ ...helm.ClientOption) (helm.Client, error) {
+				return nil, errors.New("factory failed")
+			})("default")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
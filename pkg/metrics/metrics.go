@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "argocd_operator_extension"
+
+	// ResultSuccess - result label value of successful operations
+	ResultSuccess = "success"
+	// ResultError - result label value of failed operations
+	ResultError = "error"
+
+	// HashResultSkipped - result label value if the helm upgrade was skipped because the hash did not change
+	HashResultSkipped = "skipped"
+	// HashResultChanged - result label value if the helm upgrade was executed because the hash changed
+	HashResultChanged = "changed"
+)
+
+var (
+	// HelmOperations counts the helm operations by operation and result
+	HelmOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "helm_operations_total",
+		Help:      "Total number of helm operations by operation and result.",
+	}, []string{"operation", "result"})
+
+	// HelmOperationDuration observes the latency of helm operations by operation and result
+	HelmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "helm_operation_duration_seconds",
+		Help:      "Latency of helm operations in seconds by operation and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"operation", "result"})
+
+	// BoundNamespaces is the number of namespaces bound to an ArgoCD instance
+	BoundNamespaces = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bound_namespaces",
+		Help:      "Number of namespaces bound to an ArgoCD instance.",
+	}, []string{"argocd"})
+
+	// ClusterModeInstances is the number of ArgoCD instances running in cluster mode
+	ClusterModeInstances = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_mode_instances",
+		Help:      "Number of ArgoCD instances running in cluster mode.",
+	})
+
+	// ImageUpdatePatches counts the patches applied to the images and versions of ArgoCD instances
+	ImageUpdatePatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_update_patches_total",
+		Help:      "Total number of image and version patches applied to ArgoCD instances.",
+	})
+
+	// HelmHashChecks counts the comparisons of the helm hash by result (skipped or changed)
+	HelmHashChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "helm_hash_checks_total",
+		Help:      "Total number of helm hash comparisons by result, 'skipped' if the helm upgrade was not needed.",
+	}, []string{"result"})
+
+	// ChartLoadFailures counts the failures to load the helm chart
+	ChartLoadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chart_load_failures_total",
+		Help:      "Total number of failures to load the helm chart.",
+	})
+
+	// clusterMode tracks the ArgoCD instances in cluster mode in order to count them
+	clusterMode = struct {
+		sync.Mutex
+		instances map[string]bool
+	}{instances: make(map[string]bool)}
+)
+
+func init() {
+	// register the metrics with the global registry of controller-runtime
+	metrics.Registry.MustRegister(
+		HelmOperations,
+		HelmOperationDuration,
+		BoundNamespaces,
+		ClusterModeInstances,
+		ImageUpdatePatches,
+		HelmHashChecks,
+		ChartLoadFailures,
+	)
+}
+
+// ObserveInstance records the number of bound namespaces and the mode of an ArgoCD instance
+func ObserveInstance(argocd string, cluster bool, namespaces int) {
+	BoundNamespaces.WithLabelValues(argocd).Set(float64(namespaces))
+	setClusterMode(argocd, cluster)
+}
+
+// ForgetInstance removes the metrics of an ArgoCD instance which does not exist anymore
+func ForgetInstance(argocd string) {
+	BoundNamespaces.DeleteLabelValues(argocd)
+	setClusterMode(argocd, false)
+}
+
+func setClusterMode(argocd string, cluster bool) {
+	clusterMode.Lock()
+	defer clusterMode.Unlock()
+
+	if cluster {
+		clusterMode.instances[argocd] = true
+	} else {
+		delete(clusterMode.instances, argocd)
+	}
+	ClusterModeInstances.Set(float64(len(clusterMode.instances)))
+}
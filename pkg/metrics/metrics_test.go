@@ -0,0 +1,59 @@
+package metrics_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/metrics"
+)
+
+var _ = Describe("Metrics", func() {
+	Context("Registry", func() {
+		It("should_register_metrics", func() {
+			metrics.ChartLoadFailures.Inc()
+
+			families, err := ctrlmetrics.Registry.Gather()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			var names []string
+			for _, family := range families {
+				names = append(names, family.GetName())
+			}
+			Ω(names).Should(ContainElement("argocd_operator_extension_chart_load_failures_total"))
+		})
+	})
+	Context("ObserveInstance", func() {
+		AfterEach(func() {
+			metrics.ForgetInstance("default/argocd1")
+			metrics.ForgetInstance("default/argocd2")
+		})
+		It("should_set_bound_namespaces", func() {
+			metrics.ObserveInstance("default/argocd1", false, 3)
+
+			Ω(testutil.ToFloat64(metrics.BoundNamespaces.WithLabelValues("default/argocd1"))).Should(Equal(3.0))
+		})
+		It("should_count_cluster_mode_instances", func() {
+			metrics.ObserveInstance("default/argocd1", true, 0)
+			metrics.ObserveInstance("default/argocd2", true, 0)
+			metrics.ObserveInstance("default/argocd2", true, 0)
+
+			Ω(testutil.ToFloat64(metrics.ClusterModeInstances)).Should(Equal(2.0))
+
+			metrics.ObserveInstance("default/argocd2", false, 1)
+
+			Ω(testutil.ToFloat64(metrics.ClusterModeInstances)).Should(Equal(1.0))
+		})
+	})
+	Context("ForgetInstance", func() {
+		It("should_remove_instance", func() {
+			metrics.ObserveInstance("default/argocd", true, 1)
+			metrics.ForgetInstance("default/argocd")
+
+			Ω(testutil.ToFloat64(metrics.ClusterModeInstances)).Should(Equal(0.0))
+			Ω(testutil.CollectAndCount(metrics.BoundNamespaces)).Should(Equal(0))
+		})
+	})
+})
@@ -8,6 +8,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 	chart "helm.sh/helm/v3/pkg/chart"
 	chartutil "helm.sh/helm/v3/pkg/chartutil"
+	release "helm.sh/helm/v3/pkg/release"
 	reflect "reflect"
 )
 
@@ -48,6 +49,36 @@ func (mr *MockClientMockRecorder) Install(arg0, arg1, arg2 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Install", reflect.TypeOf((*MockClient)(nil).Install), arg0, arg1, arg2)
 }
 
+// List mocks base method
+func (m *MockClient) List() ([]*release.Release, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List")
+	ret0, _ := ret[0].([]*release.Release)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (mr *MockClientMockRecorder) List() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockClient)(nil).List))
+}
+
+// Migrate mocks base method
+func (m *MockClient) Migrate(arg0, arg1 string, arg2 bool) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Migrate", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Migrate indicates an expected call of Migrate
+func (mr *MockClientMockRecorder) Migrate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Migrate", reflect.TypeOf((*MockClient)(nil).Migrate), arg0, arg1, arg2)
+}
+
 // Uninstall mocks base method
 func (m *MockClient) Uninstall(arg0 string) error {
 	m.ctrl.T.Helper()
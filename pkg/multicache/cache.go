@@ -0,0 +1,174 @@
+package multicache
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Builder creates a cache which is restricted to a list of namespaces like the cache.MultiNamespacedCacheBuilder but
+// delegates the cluster-scoped objects (e.g. Namespaces) to a single cluster-wide cache. The multi-namespaced cache
+// would start an informer per namespace for cluster-scoped objects and fails to get them. The namespaced kinds which
+// are needed outside of the namespaces (e.g. the RoleBindings of the bound namespaces) are delegated to the
+// cluster-wide cache as well.
+func Builder(namespaces []string, clusterWide ...schema.GroupKind) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		namespaced, err := cache.MultiNamespacedCacheBuilder(namespaces)(config, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Namespace = ""
+		cluster, err := cache.New(config, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		mapper := opts.Mapper
+		if mapper == nil {
+			if mapper, err = apiutil.NewDynamicRESTMapper(config); err != nil {
+				return nil, err
+			}
+		}
+
+		return New(namespaced, cluster, mapper, opts.Scheme, clusterWide...), nil
+	}
+}
+
+// New creates a cache which uses the namespaced cache for namespace-scoped objects and the cluster cache for
+// cluster-scoped objects and the cluster-wide kinds
+func New(namespaced, cluster cache.Cache, mapper meta.RESTMapper, scheme *runtime.Scheme, clusterWide ...schema.GroupKind) cache.Cache {
+	return &delegatingCache{
+		namespaced:  namespaced,
+		cluster:     cluster,
+		mapper:      mapper,
+		scheme:      scheme,
+		clusterWide: clusterWide,
+	}
+}
+
+// delegatingCache implements the cache.Cache interface
+type delegatingCache struct {
+	namespaced cache.Cache
+	cluster    cache.Cache
+	mapper     meta.RESTMapper
+	scheme     *runtime.Scheme
+
+	clusterWide []schema.GroupKind
+}
+
+// Get implements the client.Reader interface
+func (c *delegatingCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	delegate, err := c.delegateForObject(obj)
+	if err != nil {
+		return err
+	}
+	return delegate.Get(ctx, key, obj)
+}
+
+// List implements the client.Reader interface
+func (c *delegatingCache) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	delegate, err := c.delegateForObject(list)
+	if err != nil {
+		return err
+	}
+	return delegate.List(ctx, list, opts...)
+}
+
+// GetInformer implements the cache.Informers interface
+func (c *delegatingCache) GetInformer(ctx context.Context, obj runtime.Object) (cache.Informer, error) {
+	delegate, err := c.delegateForObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return delegate.GetInformer(ctx, obj)
+}
+
+// GetInformerForKind implements the cache.Informers interface
+func (c *delegatingCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	delegate, err := c.delegateForKind(gvk)
+	if err != nil {
+		return nil, err
+	}
+	return delegate.GetInformerForKind(ctx, gvk)
+}
+
+// Start implements the cache.Informers interface and starts both caches until the stop channel is closed
+func (c *delegatingCache) Start(stop <-chan struct{}) error {
+	errs := make(chan error, 1)
+	go func() {
+		if err := c.cluster.Start(stop); err != nil {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	}()
+	go func() {
+		if err := c.namespaced.Start(stop); err != nil {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		return nil
+	}
+}
+
+// WaitForCacheSync implements the cache.Informers interface
+func (c *delegatingCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	return c.cluster.WaitForCacheSync(stop) && c.namespaced.WaitForCacheSync(stop)
+}
+
+// IndexField implements the client.FieldIndexer interface
+func (c *delegatingCache) IndexField(ctx context.Context, obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	delegate, err := c.delegateForObject(obj)
+	if err != nil {
+		return err
+	}
+	return delegate.IndexField(ctx, obj, field, extractValue)
+}
+
+func (c *delegatingCache) delegateForObject(obj runtime.Object) (cache.Cache, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	// lists are delegated like their items
+	if meta.IsListType(obj) {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+
+	return c.delegateForKind(gvk)
+}
+
+func (c *delegatingCache) delegateForKind(gvk schema.GroupVersionKind) (cache.Cache, error) {
+	for _, gk := range c.clusterWide {
+		if gk == gvk.GroupKind() {
+			return c.cluster, nil
+		}
+	}
+
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return c.cluster, nil
+	}
+	return c.namespaced, nil
+}
@@ -0,0 +1,81 @@
+package multicache_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/multicache"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		c cache.Cache
+	)
+	BeforeEach(func() {
+		s := scheme.Scheme
+		Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+		mapper.Add(argoprojv1alpha1.SchemeGroupVersion.WithKind("ArgoCD"), meta.RESTScopeNamespace)
+
+		namespaced := testCache(s, &argoprojv1alpha1.ArgoCD{ObjectMeta: metav1.ObjectMeta{Name: "argocd", Namespace: "argocd-a"}})
+		cluster := testCache(s, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd-a"}})
+
+		c = multicache.New(namespaced, cluster, mapper, s)
+	})
+	It("should_get_cluster_scoped_objects_from_cluster_cache", func() {
+		Ω(c.Get(context.TODO(), types.NamespacedName{Name: "argocd-a"}, &corev1.Namespace{})).ShouldNot(HaveOccurred())
+
+		list := corev1.NamespaceList{}
+		Ω(c.List(context.TODO(), &list)).ShouldNot(HaveOccurred())
+		Ω(list.Items).Should(HaveLen(1))
+	})
+	It("should_get_namespaced_objects_from_namespaced_cache", func() {
+		Ω(c.Get(context.TODO(), types.NamespacedName{Name: "argocd", Namespace: "argocd-a"}, &argoprojv1alpha1.ArgoCD{})).ShouldNot(HaveOccurred())
+
+		list := argoprojv1alpha1.ArgoCDList{}
+		Ω(c.List(context.TODO(), &list)).ShouldNot(HaveOccurred())
+		Ω(list.Items).Should(HaveLen(1))
+	})
+	It("should_get_cluster_wide_kinds_from_cluster_cache", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(rbacv1.SchemeGroupVersion.WithKind("RoleBinding"), meta.RESTScopeNamespace)
+
+		namespaced := testCache(scheme.Scheme)
+		cluster := testCache(scheme.Scheme, &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "edit", Namespace: "myapp"}})
+
+		c = multicache.New(namespaced, cluster, mapper, scheme.Scheme, rbacv1.SchemeGroupVersion.WithKind("RoleBinding").GroupKind())
+
+		list := rbacv1.RoleBindingList{}
+		Ω(c.List(context.TODO(), &list, ctrlclient.InNamespace("myapp"))).ShouldNot(HaveOccurred())
+		Ω(list.Items).Should(HaveLen(1))
+	})
+	It("should_fail_for_unknown_kinds", func() {
+		Ω(c.Get(context.TODO(), types.NamespacedName{Name: "pod", Namespace: "argocd-a"}, &corev1.Pod{})).Should(HaveOccurred())
+	})
+})
+
+// fakeCache implements the cache.Cache interface with a fake client as reader, the informers are not used
+type fakeCache struct {
+	ctrlclient.Reader
+	cache.Informers
+}
+
+func testCache(s *runtime.Scheme, objects ...runtime.Object) cache.Cache {
+	return &fakeCache{Reader: client.NewFakeClientWithScheme(s, objects...)}
+}
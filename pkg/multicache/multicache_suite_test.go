@@ -0,0 +1,13 @@
+package multicache_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMultiCache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MultiCache Suite")
+}
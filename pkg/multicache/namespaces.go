@@ -0,0 +1,50 @@
+package multicache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Namespaces resolves the namespaces to watch from a comma separated list of names and a namespace label selector.
+// Both are combined, an empty result means all namespaces are watched. The namespaces matching the selector are only
+// resolved once, namespaces which are labelled later require a restart.
+func Namespaces(ctx context.Context, reader client.Reader, names string, selector string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[name] = true
+		}
+	}
+
+	if selector != "" {
+		s, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector '%s': %w", selector, err)
+		}
+
+		list := corev1.NamespaceList{}
+		if err := reader.List(ctx, &list, client.MatchingLabelsSelector{Selector: s}); err != nil {
+			return nil, err
+		}
+		if len(list.Items) == 0 && len(set) == 0 {
+			// don't fall back to all namespaces
+			return nil, fmt.Errorf("no namespace matches the selector '%s'", selector)
+		}
+		for _, namespace := range list.Items {
+			set[namespace.Name] = true
+		}
+	}
+
+	var ret []string
+	for name := range set {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
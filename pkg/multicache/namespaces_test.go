@@ -0,0 +1,41 @@
+package multicache_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/multicache"
+)
+
+var _ = Describe("Namespaces", func() {
+	cl := client.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd-a", Labels: map[string]string{"team": "argocd"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "argocd-b", Labels: map[string]string{"team": "argocd"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+	)
+
+	It("should_return_all_namespaces_if_empty", func() {
+		Ω(multicache.Namespaces(context.TODO(), cl, "", "")).Should(BeEmpty())
+	})
+	It("should_split_comma_separated_list", func() {
+		Ω(multicache.Namespaces(context.TODO(), cl, "argocd-b, argocd-a,", "")).Should(Equal([]string{"argocd-a", "argocd-b"}))
+	})
+	It("should_combine_list_and_selector", func() {
+		Ω(multicache.Namespaces(context.TODO(), cl, "other,argocd-a", "team=argocd")).Should(Equal([]string{"argocd-a", "argocd-b", "other"}))
+	})
+	It("should_fail_if_selector_matches_nothing", func() {
+		_, err := multicache.Namespaces(context.TODO(), cl, "", "team=unknown")
+		Ω(err).Should(HaveOccurred())
+	})
+	It("should_fail_on_invalid_selector", func() {
+		_, err := multicache.Namespaces(context.TODO(), cl, "", "team in (")
+		Ω(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+const (
+	// BeginMarker - first line of the generated block in the policy CSV, the lines outside the block are kept
+	BeginMarker = "# BEGIN argocd-operator-extension: generated from the RoleBindings of the bound namespaces"
+	// EndMarker - last line of the generated block in the policy CSV
+	EndMarker = "# END argocd-operator-extension"
+)
+
+// Access is the access to the applications of a namespace which a Kubernetes role grants in Argo CD
+type Access string
+
+const (
+	// AccessView - get the applications
+	AccessView Access = "view"
+	// AccessEdit - get, create and sync the applications
+	AccessEdit Access = "edit"
+)
+
+// actions returns the Argo CD actions on applications which are granted by the access
+func (a Access) actions() []string {
+	switch a {
+	case AccessEdit:
+		return []string{"get", "create", "sync"}
+	case AccessView:
+		return []string{"get"}
+	}
+	return nil
+}
+
+// Roles maps the names of Kubernetes cluster roles to the access they grant in Argo CD
+type Roles map[string]Access
+
+// ParseRoles parses the comma separated names of the roles which grant the edit and the view access, a role in both
+// lists grants the edit access
+func ParseRoles(edit, view string) Roles {
+	ret := Roles{}
+	for _, name := range strings.Split(view, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret[name] = AccessView
+		}
+	}
+	for _, name := range strings.Split(edit, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret[name] = AccessEdit
+		}
+	}
+	return ret
+}
+
+// Generate returns the sorted Argo CD policy lines which grant the groups bound to the roles in a namespace access to
+// the applications of the AppProject named after the namespace. Only bindings of cluster roles are considered, since a
+// namespaced Role can be named like any of them.
+func Generate(namespace string, bindings []rbacv1.RoleBinding, roles Roles) []string {
+	set := make(map[string]bool)
+	for _, binding := range bindings {
+		access, ok := roles[binding.RoleRef.Name]
+		if !ok || binding.RoleRef.APIGroup != rbacv1.GroupName || binding.RoleRef.Kind != "ClusterRole" {
+			continue
+		}
+
+		for _, subject := range binding.Subjects {
+			// groups with separators would corrupt the CSV
+			if subject.Kind != rbacv1.GroupKind || subject.Name == "" || strings.ContainsAny(subject.Name, ",\n\r") {
+				continue
+			}
+			for _, action := range access.actions() {
+				set[fmt.Sprintf("p, %s, applications, %s, %s/*, allow", subject.Name, action, namespace)] = true
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(set))
+	for line := range set {
+		ret = append(ret, line)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Merge replaces the generated block in the policy CSV by the given lines, the block is removed if there are none
+func Merge(policy string, lines []string) string {
+	var kept []string
+	generated := false
+	for _, line := range strings.Split(policy, "\n") {
+		switch {
+		case strings.TrimSpace(line) == BeginMarker:
+			generated = true
+		case strings.TrimSpace(line) == EndMarker:
+			generated = false
+		case !generated:
+			kept = append(kept, line)
+		}
+	}
+
+	ret := strings.TrimRight(strings.Join(kept, "\n"), "\n")
+	if len(lines) > 0 {
+		if ret != "" {
+			ret += "\n"
+		}
+		ret += strings.Join(append(append([]string{BeginMarker}, lines...), EndMarker), "\n")
+	}
+	if ret != "" {
+		ret += "\n"
+	}
+	return ret
+}
@@ -0,0 +1,84 @@
+package policy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/policy"
+)
+
+var _ = Describe("Policy", func() {
+	roles := policy.ParseRoles("admin,edit", "view")
+
+	Context("ParseRoles", func() {
+		It("should_prefer_edit_access", func() {
+			Ω(policy.ParseRoles("edit", " view, edit ")).Should(Equal(policy.Roles{"edit": policy.AccessEdit, "view": policy.AccessView}))
+		})
+	})
+	Context("Generate", func() {
+		It("should_grant_groups_access_by_role", func() {
+			bindings := []rbacv1.RoleBinding{
+				testBinding("ClusterRole", "edit", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"}),
+				testBinding("ClusterRole", "view", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "auditors"}),
+			}
+
+			Ω(policy.Generate("myapp", bindings, roles)).Should(Equal([]string{
+				"p, auditors, applications, get, myapp/*, allow",
+				"p, developers, applications, create, myapp/*, allow",
+				"p, developers, applications, get, myapp/*, allow",
+				"p, developers, applications, sync, myapp/*, allow",
+			}))
+		})
+		It("should_ignore_other_subjects_and_roles", func() {
+			bindings := []rbacv1.RoleBinding{
+				testBinding("ClusterRole", "edit",
+					rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"},
+					rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "argocd-application-controller"},
+					rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "a,b"},
+				),
+				testBinding("ClusterRole", "cluster-admin", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"}),
+				testBinding("Role", "edit", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"}),
+			}
+
+			Ω(policy.Generate("myapp", bindings, roles)).Should(BeEmpty())
+		})
+		It("should_deduplicate_lines", func() {
+			bindings := []rbacv1.RoleBinding{
+				testBinding("ClusterRole", "view", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"}),
+				testBinding("Role", "view", rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"}),
+			}
+
+			Ω(policy.Generate("myapp", bindings, roles)).Should(Equal([]string{"p, developers, applications, get, myapp/*, allow"}))
+		})
+	})
+	Context("Merge", func() {
+		lines := []string{"p, developers, applications, get, myapp/*, allow"}
+
+		It("should_append_block", func() {
+			Ω(policy.Merge("g, admins, role:admin\n", lines)).Should(Equal(
+				"g, admins, role:admin\n" + policy.BeginMarker + "\np, developers, applications, get, myapp/*, allow\n" + policy.EndMarker + "\n"))
+		})
+		It("should_replace_block_and_keep_other_lines", func() {
+			current := "g, admins, role:admin\n" + policy.BeginMarker + "\np, old, applications, get, old/*, allow\n" + policy.EndMarker + "\ng, ops, role:readonly\n"
+
+			Ω(policy.Merge(current, lines)).Should(Equal(
+				"g, admins, role:admin\ng, ops, role:readonly\n" + policy.BeginMarker + "\np, developers, applications, get, myapp/*, allow\n" + policy.EndMarker + "\n"))
+		})
+		It("should_be_stable", func() {
+			merged := policy.Merge("g, admins, role:admin", lines)
+			Ω(policy.Merge(merged, lines)).Should(Equal(merged))
+		})
+		It("should_remove_block_without_lines", func() {
+			Ω(policy.Merge(policy.Merge("", lines), nil)).Should(BeEmpty())
+		})
+	})
+})
+
+func testBinding(kind, name string, subjects ...rbacv1.Subject) rbacv1.RoleBinding {
+	return rbacv1.RoleBinding{
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: kind, Name: name},
+		Subjects: subjects,
+	}
+}
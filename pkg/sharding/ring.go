@@ -0,0 +1,74 @@
+package sharding
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes - number of points per member on the hash ring
+const DefaultVirtualNodes = 100
+
+// Ring is a consistent hash ring which assigns keys to members. Adding or removing a member only moves the keys of
+// this member, all the other assignments remain stable.
+type Ring struct {
+	members []string
+	points  []uint64
+	owners  map[uint64]string
+}
+
+// NewRing creates a hash ring of the given members with a number of virtual nodes per member
+func NewRing(members []string, virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{
+		owners: make(map[uint64]string),
+	}
+	for _, member := range members {
+		r.members = append(r.members, member)
+		for i := 0; i < virtualNodes; i++ {
+			point := hash(member + "#" + strconv.Itoa(i))
+			if owner, ok := r.owners[point]; ok {
+				// on a collision the lexicographically smaller member wins in order to be deterministic
+				if owner < member {
+					continue
+				}
+			} else {
+				r.points = append(r.points, point)
+			}
+			r.owners[point] = member
+		}
+	}
+	sort.Strings(r.members)
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+
+	return r
+}
+
+// Members returns the sorted members of the ring
+func (r *Ring) Members() []string {
+	return r.members
+}
+
+// Owner returns the member the key is assigned to, an empty string if the ring has no members
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	point := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= point })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hash returns the position of a key on the ring, a cryptographic hash spreads similar keys (e.g. 'a#1' and 'a#2')
+func hash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
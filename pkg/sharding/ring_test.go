@@ -0,0 +1,39 @@
+package sharding_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/sharding"
+)
+
+var _ = Describe("Ring", func() {
+	It("should_return_empty_owner_without_members", func() {
+		Ω(sharding.NewRing(nil, 0).Owner("default/argocd")).Should(BeEmpty())
+	})
+	It("should_distribute_keys_across_members", func() {
+		ring := sharding.NewRing([]string{"a", "b", "c"}, sharding.DefaultVirtualNodes)
+
+		owners := map[string]int{}
+		for i := 0; i < 300; i++ {
+			owners[ring.Owner(fmt.Sprintf("namespace-%d/argocd", i))]++
+		}
+		Ω(owners).Should(HaveLen(3))
+		for _, count := range owners {
+			Ω(count).Should(BeNumerically(">", 50))
+		}
+	})
+	It("should_only_move_keys_of_leaving_member", func() {
+		before := sharding.NewRing([]string{"a", "b", "c"}, sharding.DefaultVirtualNodes)
+		after := sharding.NewRing([]string{"a", "b"}, sharding.DefaultVirtualNodes)
+
+		for i := 0; i < 300; i++ {
+			key := fmt.Sprintf("namespace-%d/argocd", i)
+			if owner := before.Owner(key); owner != "c" {
+				Ω(after.Owner(key)).Should(Equal(owner))
+			}
+		}
+	})
+})
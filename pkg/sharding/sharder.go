@@ -0,0 +1,296 @@
+package sharding
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	"github.com/go-logr/logr"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// LeasePrefix - name prefix of the Lease objects of the replicas
+	LeasePrefix = "argocd-operator-extension-shard-"
+
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewInterval = 5 * time.Second
+)
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
+
+// Sharder distributes the ArgoCD instances across the replicas of the extension. Every replica holds a Lease object
+// which it renews periodically, the replicas with a valid Lease are the members of a consistent hash ring which
+// assigns each ArgoCD instance to exactly one replica. If a replica joins or leaves, all the ArgoCD instances are
+// enqueued in order that the new owners reconcile them.
+//
+// An instance is handed over to its new owner only after the previous owner observed the new members or its Lease
+// expired, i.e. one Lease duration after the members changed, unless the previous owner left the ring. A replica
+// which did not synchronize the members within the Lease duration does not own any instance.
+type Sharder struct {
+	client.Client
+	Log logr.Logger
+
+	// Reader reads the Lease objects bypassing the cache (e.g. the API reader of the manager), the client is used if nil
+	Reader client.Reader
+
+	// Identity is the unique name of the replica, e.g. the name of the pod
+	Identity string
+	// Namespace of the Lease objects
+	Namespace string
+	// LeaseDuration is the duration after which the Lease of a replica which stopped renewing expires
+	LeaseDuration time.Duration
+	// RenewInterval is the interval in which the Lease is renewed and the members are synchronized
+	RenewInterval time.Duration
+
+	// Clock is used to expire the Leases and to fence the handover of the instances (default: real clock)
+	Clock clock.PassiveClock
+
+	mu sync.RWMutex
+	// ring assigns the instances to the current members
+	ring *Ring
+	// settled is the ring of the members before the last change, its owners hand over until the handover time
+	settled *Ring
+	// handover is the time after which the instances are owned according to the current ring
+	handover time.Time
+	// pending is set until the instances were enqueued after the handover
+	pending bool
+	// synced is the time of the last synchronization of the members
+	synced time.Time
+	// queue of the controller the instances are enqueued to on a rebalancing
+	queue workqueue.RateLimitingInterface
+}
+
+// Source returns the source of the reconcile requests which are enqueued for every ArgoCD instance on a rebalancing
+func (s *Sharder) Source() source.Source {
+	return &queueSource{sharder: s}
+}
+
+// queueSource enqueues the rebalanced instances to the queue of the controller directly, the queue is unbounded and
+// deduplicates the requests hence the synchronization of the members is never blocked
+type queueSource struct {
+	sharder *Sharder
+}
+
+// Start implements the source.Source interface
+func (q *queueSource) Start(_ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+	q.sharder.mu.Lock()
+	defer q.sharder.mu.Unlock()
+
+	q.sharder.queue = queue
+	return nil
+}
+
+// Owns returns true if the ArgoCD instance is assigned to this replica. Before the replica joined the ring and after
+// it failed to synchronize the members within the Lease duration it owns no instance.
+func (s *Sharder) Owns(argocd types.NamespacedName) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	if s.ring == nil || !now.Before(s.synced.Add(s.leaseDuration())) {
+		return false
+	}
+	if s.ring.Owner(argocd.String()) != s.Identity {
+		return false
+	}
+	if !now.Before(s.handover) {
+		return true
+	}
+
+	// the previous owner has to stop reconciling the instance before it is taken over, unless it left the ring
+	if s.settled == nil {
+		return false
+	}
+	previous := s.settled.Owner(argocd.String())
+	return previous == s.Identity || !contains(s.ring.Members(), previous)
+}
+
+// NeedLeaderElection implements the manager.LeaderElectionRunnable interface, every replica has to hold its Lease
+func (s *Sharder) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements the manager.Runnable interface and synchronizes the members until the stop channel is closed
+func (s *Sharder) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := s.Sync(context.Background()); err != nil {
+			s.Log.Error(err, "unable to synchronize shard members")
+		}
+	}, s.renewInterval(), stop)
+
+	// stop owning the instances before leaving the ring in order that the other replicas take over immediately
+	s.mu.Lock()
+	s.ring = nil
+	s.mu.Unlock()
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: s.leaseName(), Namespace: s.Namespace}}
+	if err := s.Delete(context.Background(), lease); err != nil && !errors.IsNotFound(err) {
+		s.Log.Error(err, "unable to delete lease", "lease", lease.Name)
+	}
+	return nil
+}
+
+// Sync renews the Lease of the replica and rebuilds the ring from the replicas holding a valid Lease
+func (s *Sharder) Sync(ctx context.Context) error {
+	if err := s.renew(ctx); err != nil {
+		return err
+	}
+
+	leases := coordinationv1.LeaseList{}
+	if err := s.reader().List(ctx, &leases, client.InNamespace(s.Namespace), client.MatchingLabels{constants.LabelShard: "true"}); err != nil {
+		return err
+	}
+
+	now := s.now()
+	var members []string
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		if lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).After(now) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+	sort.Strings(members)
+
+	s.mu.Lock()
+	s.synced = now
+	changed := s.ring == nil || !reflect.DeepEqual(s.ring.Members(), members)
+	if changed {
+		if s.ring != nil && !now.Before(s.handover) {
+			s.settled = s.ring
+		}
+		s.ring = NewRing(members, DefaultVirtualNodes)
+
+		// a replica which starts as the only member does not take over from anyone
+		s.handover = now.Add(s.leaseDuration())
+		if s.settled == nil && reflect.DeepEqual(members, []string{s.Identity}) {
+			s.handover = now
+		}
+		s.pending = s.handover.After(now)
+	}
+	handover := s.pending && !now.Before(s.handover)
+	if handover {
+		s.pending = false
+	}
+	s.mu.Unlock()
+
+	if changed {
+		s.Log.Info("shard members changed, rebalancing ArgoCD instances", "members", members)
+		return s.rebalance(ctx)
+	}
+	if handover {
+		s.Log.Info("shard handover completed, rebalancing ArgoCD instances", "members", members)
+		return s.rebalance(ctx)
+	}
+	return nil
+}
+
+// renew creates or renews the Lease of the replica
+func (s *Sharder) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(s.now())
+	duration := int32(s.leaseDuration().Seconds())
+
+	lease := &coordinationv1.Lease{}
+	if err := s.reader().Get(ctx, types.NamespacedName{Name: s.leaseName(), Namespace: s.Namespace}, lease); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		return s.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.leaseName(),
+				Namespace: s.Namespace,
+				Labels:    map[string]string{constants.LabelShard: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+	}
+
+	lease.Spec.HolderIdentity = &s.Identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	return s.Update(ctx, lease)
+}
+
+// rebalance enqueues every ArgoCD instance, the instances are not enqueued before the controller started since it
+// reconciles all of them initially
+func (s *Sharder) rebalance(ctx context.Context) error {
+	s.mu.RLock()
+	queue := s.queue
+	s.mu.RUnlock()
+	if queue == nil {
+		return nil
+	}
+
+	list := argoprojv1alpha1.ArgoCDList{}
+	if err := s.List(ctx, &list); err != nil {
+		return err
+	}
+
+	for _, argocd := range list.Items {
+		queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: argocd.Namespace, Name: argocd.Name}})
+	}
+	return nil
+}
+
+func (s *Sharder) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock.Now()
+}
+
+func (s *Sharder) reader() client.Reader {
+	if s.Reader != nil {
+		return s.Reader
+	}
+	return s.Client
+}
+
+func (s *Sharder) leaseName() string {
+	return LeasePrefix + s.Identity
+}
+
+func (s *Sharder) leaseDuration() time.Duration {
+	if s.LeaseDuration > 0 {
+		return s.LeaseDuration
+	}
+	return defaultLeaseDuration
+}
+
+func (s *Sharder) renewInterval() time.Duration {
+	if s.RenewInterval > 0 {
+		return s.RenewInterval
+	}
+	return defaultRenewInterval
+}
+
+func contains(slice []string, str string) bool {
+	for _, v := range slice {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package sharding_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	argoprojv1alpha1 "github.com/argoproj-labs/argocd-operator/pkg/apis/argoproj/v1alpha1"
+	logr "github.com/go-logr/logr/testing"
+	"github.com/snorwin/argocd-operator-extension/pkg/constants"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	client "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/sharding"
+)
+
+var _ = Describe("Sharder", func() {
+	It("should_not_own_instances_before_sync", func() {
+		s := testSharder(testClient(), "a")
+		Ω(s.Owns(types.NamespacedName{Name: "argocd", Namespace: "default"})).Should(BeFalse())
+	})
+	It("should_assign_each_instance_to_exactly_one_replica", func() {
+		cl := testClient()
+		now := clock.NewFakeClock(time.Now())
+		a := testSharder(cl, "a")
+		a.Clock = now
+		b := testSharder(cl, "b")
+		b.Clock = now
+
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		Ω(b.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		// a joined before b and has to synchronize again
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+
+		// b takes over after the lease of a would have expired without observing b
+		testRenew(now, a, b)
+
+		owned := map[string]int{}
+		for i := 0; i < 100; i++ {
+			argocd := types.NamespacedName{Name: "argocd", Namespace: fmt.Sprintf("namespace-%d", i)}
+			Ω(a.Owns(argocd)).ShouldNot(Equal(b.Owns(argocd)))
+			if a.Owns(argocd) {
+				owned["a"]++
+			} else {
+				owned["b"]++
+			}
+		}
+		Ω(owned).Should(HaveLen(2))
+	})
+	It("should_ignore_expired_leases", func() {
+		holder := "b"
+		duration := int32(15)
+		renew := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+		expired := &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      sharding.LeasePrefix + holder,
+				Namespace: "default",
+				Labels:    map[string]string{constants.LabelShard: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				RenewTime:            &renew,
+			},
+		}
+
+		a := testSharder(testClient(expired), "a")
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+
+		for i := 0; i < 100; i++ {
+			Ω(a.Owns(types.NamespacedName{Name: "argocd", Namespace: fmt.Sprintf("namespace-%d", i)})).Should(BeTrue())
+		}
+	})
+	It("should_hand_over_instances_after_the_lease_duration", func() {
+		cl := testClient()
+		now := clock.NewFakeClock(time.Now())
+		a := testSharder(cl, "a")
+		a.Clock = now
+		b := testSharder(cl, "b")
+		b.Clock = now
+
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		Ω(b.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+
+		// a owned all instances before b joined
+		var moved []types.NamespacedName
+		for i := 0; i < 100; i++ {
+			argocd := types.NamespacedName{Name: "argocd", Namespace: fmt.Sprintf("namespace-%d", i)}
+			if !a.Owns(argocd) {
+				moved = append(moved, argocd)
+			}
+		}
+		Ω(moved).ShouldNot(BeEmpty())
+
+		// b waits until a observed it or the lease of a expired
+		for _, argocd := range moved {
+			Ω(b.Owns(argocd)).Should(BeFalse())
+		}
+
+		testRenew(now, a, b)
+		for _, argocd := range moved {
+			Ω(a.Owns(argocd)).Should(BeFalse())
+			Ω(b.Owns(argocd)).Should(BeTrue())
+		}
+	})
+	It("should_take_over_instances_of_replicas_which_left_immediately", func() {
+		cl := testClient()
+		now := clock.NewFakeClock(time.Now())
+		a := testSharder(cl, "a")
+		a.Clock = now
+		b := testSharder(cl, "b")
+		b.Clock = now
+
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		Ω(b.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		testRenew(now, a, b)
+
+		// b leaves the ring
+		Ω(cl.Delete(context.TODO(), &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: sharding.LeasePrefix + "b", Namespace: "default"}})).ShouldNot(HaveOccurred())
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+
+		for i := 0; i < 100; i++ {
+			Ω(a.Owns(types.NamespacedName{Name: "argocd", Namespace: fmt.Sprintf("namespace-%d", i)})).Should(BeTrue())
+		}
+	})
+	It("should_not_own_instances_without_sync_within_lease_duration", func() {
+		now := clock.NewFakeClock(time.Now())
+		a := testSharder(testClient(), "a")
+		a.Clock = now
+
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		Ω(a.Owns(types.NamespacedName{Name: "argocd", Namespace: "default"})).Should(BeTrue())
+
+		now.Step(15 * time.Second)
+		Ω(a.Owns(types.NamespacedName{Name: "argocd", Namespace: "default"})).Should(BeFalse())
+	})
+	It("should_enqueue_all_instances_on_rebalancing", func() {
+		argocd := &argoprojv1alpha1.ArgoCD{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "argocd",
+				Namespace: "default",
+			},
+		}
+
+		cl := testClient(argocd)
+		a := testSharder(cl, "a")
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+		Ω(a.Source().Start(nil, queue)).ShouldNot(HaveOccurred())
+
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		// the members did not change, no rebalancing
+		Ω(a.Sync(context.TODO())).ShouldNot(HaveOccurred())
+
+		lease := &coordinationv1.Lease{}
+		Ω(cl.Get(context.TODO(), types.NamespacedName{Name: sharding.LeasePrefix + "a", Namespace: "default"}, lease)).ShouldNot(HaveOccurred())
+		Ω(*lease.Spec.HolderIdentity).Should(Equal("a"))
+		Ω(queue.Len()).Should(Equal(1))
+		item, _ := queue.Get()
+		Ω(item).Should(Equal(reconcile.Request{NamespacedName: types.NamespacedName{Name: argocd.Name, Namespace: argocd.Namespace}}))
+	})
+})
+
+func testClient(objects ...runtime.Object) ctrlclient.Client {
+	s := scheme.Scheme
+	Ω(argoprojv1alpha1.SchemeBuilder.AddToScheme(s)).ShouldNot(HaveOccurred())
+
+	return client.NewFakeClientWithScheme(s, objects...)
+}
+
+func testSharder(cl ctrlclient.Client, identity string) *sharding.Sharder {
+	return &sharding.Sharder{
+		Client:    cl,
+		Log:       logr.NullLogger{},
+		Identity:  identity,
+		Namespace: "default",
+	}
+}
+
+// testRenew synchronizes the sharders every 5 seconds until the lease duration passed
+func testRenew(now *clock.FakeClock, sharders ...*sharding.Sharder) {
+	for i := 0; i < 3; i++ {
+		now.Step(5 * time.Second)
+		for _, s := range sharders {
+			Ω(s.Sync(context.TODO())).ShouldNot(HaveOccurred())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName - name of the tracer (instrumentation library) of the extension
+	TracerName = "github.com/snorwin/argocd-operator-extension"
+	// ServiceName - name of the service reported with the exported spans
+	ServiceName = "argocd-operator-extension"
+
+	// AttributeArgoCD - span attribute key of the ArgoCD namespaced name
+	AttributeArgoCD = attribute.Key("argocd")
+)
+
+// Setup registers a global tracer provider which exports the spans via OTLP/HTTP to the given endpoint (host:port)
+// and returns a function to flush and stop the exporter. Tracing is disabled if the endpoint is empty.
+func Setup(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start creates a span and a context containing the newly-created span using the global tracer provider
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error (if any) and completes the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ArgoCD creates the span attribute of the ArgoCD namespaced name
+func ArgoCD(namespacedName string) attribute.KeyValue {
+	return AttributeArgoCD.String(namespacedName)
+}
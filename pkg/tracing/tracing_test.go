@@ -0,0 +1,59 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/snorwin/argocd-operator-extension/pkg/tracing"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		exporter *tracetest.InMemoryExporter
+	)
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	})
+	Context("Setup", func() {
+		It("should_be_disabled_without_endpoint", func() {
+			shutdown, err := tracing.Setup(context.TODO(), "", false)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(shutdown(context.TODO())).ShouldNot(HaveOccurred())
+		})
+	})
+	Context("Start", func() {
+		It("should_create_child_spans_with_attributes", func() {
+			ctx, parent := tracing.Start(context.TODO(), "parent", tracing.ArgoCD("default/argocd"))
+			_, child := tracing.Start(ctx, "child")
+			tracing.End(child, nil)
+			tracing.End(parent, nil)
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(2))
+			Ω(spans[0].Name).Should(Equal("child"))
+			Ω(spans[0].Parent.SpanID()).Should(Equal(spans[1].SpanContext.SpanID()))
+			Ω(spans[1].Name).Should(Equal("parent"))
+			Ω(spans[1].Attributes).Should(ContainElement(tracing.ArgoCD("default/argocd")))
+		})
+	})
+	Context("End", func() {
+		It("should_record_error", func() {
+			_, span := tracing.Start(context.TODO(), "span")
+			tracing.End(span, errors.New("failed"))
+
+			spans := exporter.GetSpans()
+			Ω(spans).Should(HaveLen(1))
+			Ω(spans[0].Status.Code).Should(Equal(codes.Error))
+			Ω(spans[0].Events).Should(HaveLen(1))
+		})
+	})
+})